@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// checkpoint holds the aggregates of a run, periodically saved so that a soak
+// survives a crash of the generator and can be resumed into a new run.
+type checkpoint struct {
+	Saved     time.Time     `json:"saved"`
+	Elapsed   time.Duration `json:"elapsed_ns"`
+	Transmits int           `json:"transmits"`
+	Failures  int           `json:"failures"`
+	Drops     int           `json:"drops"`
+	Restarts  int           `json:"restarts"`
+	Total     time.Duration `json:"total_ns"`
+	// Latencies of the exchanges, the very histogram the run reports from.
+	Latencies latencyHistogram `json:"latencies"`
+}
+
+// merge adds an earlier run into this one, keeping this one's save time.
+func (current *checkpoint) merge(earlier checkpoint) {
+	current.Elapsed += earlier.Elapsed
+	current.Transmits += earlier.Transmits
+	current.Failures += earlier.Failures
+	current.Drops += earlier.Drops
+	current.Restarts += earlier.Restarts
+	current.Total += earlier.Total
+	current.Latencies.merge(&earlier.Latencies)
+}
+
+// histogramJSON is how a latencyHistogram is saved, listing only the buckets in use.
+type histogramJSON struct {
+	Counts  map[int]int   `json:"counts"`
+	Slowest time.Duration `json:"slowest_ns"`
+}
+
+func (histogram latencyHistogram) MarshalJSON() ([]byte, error) {
+	saved := histogramJSON{Counts: map[int]int{}, Slowest: histogram.slowest}
+	for bucket, count := range histogram.counts {
+		if count > 0 {
+			saved.Counts[bucket] = count
+		}
+	}
+	return json.Marshal(saved)
+}
+
+func (histogram *latencyHistogram) UnmarshalJSON(content []byte) error {
+	saved := histogramJSON{}
+	if err := json.Unmarshal(content, &saved); err != nil {
+		return err
+	}
+	*histogram = latencyHistogram{slowest: saved.Slowest}
+	for bucket, count := range saved.Counts {
+		if bucket < 0 || bucket >= len(histogram.counts) || count < 0 {
+			return fmt.Errorf("the latency bucket %d can't count %d exchanges", bucket, count)
+		}
+		histogram.counts[bucket] = count
+		histogram.count += count
+	}
+	return nil
+}
+
+// saveCheckpoint replaces the file atomically, so a crash mid-write keeps the previous one.
+func saveCheckpoint(path string, saved checkpoint) error {
+	content, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func loadCheckpoint(path string) (checkpoint, error) {
+	loaded := checkpoint{}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return loaded, err
+	}
+	err = json.Unmarshal(content, &loaded)
+	return loaded, err
+}
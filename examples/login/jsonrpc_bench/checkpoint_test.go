@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A run resumed from a saved checkpoint reports the percentiles of both runs, as if
+// all their exchanges had been counted in one histogram.
+func TestCheckpointRoundTrip(t *testing.T) {
+	earlier, later, all := checkpoint{Transmits: 900, Failures: 2}, checkpoint{Transmits: 100, Restarts: 1}, latencyHistogram{}
+	for i := 0; i < 900; i++ {
+		took := time.Duration(100+i%50) * time.Microsecond
+		earlier.Latencies.add(took)
+		all.add(took)
+	}
+	for i := 0; i < 100; i++ {
+		took := time.Duration(5000+i) * time.Microsecond
+		later.Latencies.add(took)
+		all.add(took)
+	}
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := saveCheckpoint(path, earlier); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Latencies != earlier.Latencies {
+		t.Fatal("the histogram changed on the way through the file")
+	}
+	later.merge(loaded)
+	if later.Transmits != 1000 || later.Failures != 2 || later.Restarts != 1 || later.Latencies.count != 1000 {
+		t.Fatalf("merged into %+v", later)
+	}
+	for _, share := range []float64{0.5, 0.9, 0.95, 0.99, 1} {
+		if merged, exact := later.Latencies.percentile(share), all.percentile(share); merged != exact {
+			t.Fatalf("merged p%g is %s instead of %s", share*100, merged, exact)
+		}
+	}
+	if later.Latencies.percentile(0.5) > 150*time.Microsecond || later.Latencies.percentile(0.95) < 5*time.Millisecond {
+		t.Fatalf("merged percentiles %s miss one of the runs", &later.Latencies)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"latencies":{"counts":{"100000":1}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCheckpoint(path); err == nil {
+		t.Fatal("a checkpoint with a bucket out of range was loaded")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// genericResult summarizes a run against a plain HTTP/JSON endpoint.
+type genericResult struct {
+	transmits int
+	failures  int
+	elapsed   time.Duration
+	total     time.Duration
+	slowest   time.Duration
+}
+
+// benchGeneric posts the bare params, without the JSON-RPC envelope, to an ordinary
+// HTTP endpoint with the standard library client, honoring the same limits as the main
+// loop, so a hung endpoint can't hold the run past -s either.
+func benchGeneric(cfg *config, endpoint string, body []byte) genericResult {
+	client := http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 1}}
+	result := genericResult{}
+	start := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(time.Duration(cfg.limitSeconds)*time.Second))
+	defer cancel()
+	for result.transmits < cfg.limitTransmits && ctx.Err() == nil {
+		sent := time.Now()
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			result.failures++
+			break
+		}
+		request.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(request)
+		if err == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// The run ended during the exchange, which isn't counted
+			break
+		}
+		if err != nil || resp.StatusCode != http.StatusOK {
+			result.failures++
+			continue
+		}
+		took := time.Since(sent)
+		result.transmits++
+		result.total += took
+		if took > result.slowest {
+			result.slowest = took
+		}
+	}
+	result.elapsed = time.Since(start)
+	return result
+}
+
+// benchCold dials a fresh connection for every sample and times only its first
+// exchange, closing it right after the reply, so no warmed state is reused.
+func benchCold(cfg *config, frames [][]byte) {
+	var connects, firsts, totals phaseStats
+	failures := 0
+	start := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(time.Duration(cfg.limitSeconds)*time.Second))
+	defer cancel()
+	for sample := 0; sample < cfg.cold && time.Since(start).Seconds() < float64(cfg.limitSeconds); sample++ {
+		dialed := time.Now()
+		conn, err := dial(cfg)
+		if err != nil {
+			failures++
+			continue
+		}
+		c := newTimedClient(cfg, conn)
+		_, timing, err := c.exchange(ctx, frames[sample%len(frames)])
+		c.Close()
+		if errors.Is(err, context.DeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			failures++
+			continue
+		}
+		connects = append(connects, timing.sent.Sub(dialed))
+		firsts = append(firsts, timing.received.Sub(timing.sent))
+		totals = append(totals, timing.received.Sub(dialed))
+	}
+	fmt.Printf("Took %s to sample %d fresh connections, %d failed\n", time.Since(start), len(totals)+failures, failures)
+	fmt.Printf("Connect: %s\n", connects)
+	fmt.Printf("First exchange: %s\n", firsts)
+	fmt.Printf("Connect and first exchange: %s\n", totals)
+	printLocalSources()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBenchGeneric(t *testing.T) {
+	cfg := defineFlags(flag.NewFlagSet("bench", flag.ContinueOnError))
+	cfg.limitSeconds, cfg.limitTransmits = 1, 5
+	answering := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("true"))
+	}))
+	defer answering.Close()
+	if result := benchGeneric(cfg, answering.URL, []byte(`{"user_id":2}`)); result.transmits != 5 || result.failures != 0 {
+		t.Fatalf("an answering endpoint got %+v", result)
+	}
+
+	// A hung endpoint still ends the comparison at -s
+	hung := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer hanging.Close()
+	defer close(hung)
+	result := benchGeneric(cfg, hanging.URL, []byte(`{"user_id":2}`))
+	if result.elapsed > 2*time.Second || result.transmits != 0 || result.failures != 0 {
+		t.Fatalf("a hung endpoint got %+v", result)
+	}
+}
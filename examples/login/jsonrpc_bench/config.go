@@ -0,0 +1,222 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds the settings of a run, the flags and the addresses resolved from them.
+type config struct {
+	limitSeconds        int
+	limitTransmits      int
+	port                int
+	batch               int
+	html                bool
+	proxy               string
+	keepAlive           time.Duration
+	gcOff               bool
+	perConnCSV          string
+	interval            time.Duration
+	maxErrors           int
+	maxErrorRate        percent
+	compareURL          string
+	ui                  bool
+	method              string
+	schemaPath          string
+	seed                int64
+	variants            int
+	verifyOrder         bool
+	cold                int
+	historyPath         string
+	compareLast         bool
+	regressionThreshold percent
+	dryRun              bool
+	baseline            bool
+	readDelay           time.Duration
+	readRate            int
+	jsonrpcVersion      string
+	checkpointPath      string
+	checkpointEvery     time.Duration
+	resumePath          string
+	framing             string
+	serverPID           int
+	serverCmd           string
+	repro               bool
+	scenario            string
+	preset              string
+	keys                int
+	valueSize           int
+	readRatio           percent
+	validate            bool
+	localAddrs          string
+
+	// servAddr is the server at -p, reached through the proxy at proxyAddr if any,
+	// with requests for the HTTP target, absolute-form through a proxy.
+	servAddr  string
+	proxyAddr string
+	target    string
+}
+
+// percent is a flag value accepting both fractions like `0.01` and percentages like `1%`.
+type percent float64
+
+func (p *percent) String() string {
+	return strconv.FormatFloat(float64(*p)*100, 'f', -1, 64) + "%"
+}
+
+func (p *percent) Set(value string) error {
+	// Dividing keeps `95%` exactly 0.95, unlike multiplying by 0.01
+	divisor := 1.0
+	if strings.HasSuffix(value, "%") {
+		value, divisor = strings.TrimSuffix(value, "%"), 100
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if !(parsed >= 0 && parsed/divisor <= 1) {
+		return fmt.Errorf("%s is out of the range from 0 to 100%%", value)
+	}
+	*p = percent(parsed / divisor)
+	return nil
+}
+
+// defineFlags binds the flags of the bench to a fresh configuration, holding the defaults.
+func defineFlags(flags *flag.FlagSet) *config {
+	cfg := &config{regressionThreshold: 0.05, readRatio: 0.5}
+	flags.IntVar(&cfg.port, "p", 8545, "port")
+	flags.IntVar(&cfg.limitSeconds, "s", 2, "Stop after n seconds")
+	flags.IntVar(&cfg.limitTransmits, "n", 1_000_000, "Stop after n requests")
+	flags.IntVar(&cfg.batch, "b", 0, "Batch n requests together")
+	flags.BoolVar(&cfg.html, "html", false, "Send an html request instead of jsonrpc")
+	flags.StringVar(&cfg.proxy, "proxy", proxyFromEnvironment(), "Route connections through this HTTP proxy, defaults to $HTTP_PROXY")
+	flags.DurationVar(&cfg.keepAlive, "keepalive", 15*time.Second, "TCP keepalive probing interval, negative to disable")
+	flags.BoolVar(&cfg.gcOff, "gc-off", false, "Disable the garbage collector, only advisable for short runs")
+	flags.StringVar(&cfg.perConnCSV, "per-conn-csv", "", "Write per-connection per-interval stats into this CSV file")
+	flags.DurationVar(&cfg.interval, "interval", time.Second, "Length of the intervals in the per-connection timeline")
+	flags.IntVar(&cfg.maxErrors, "max-errors", 0, "Abort once more than n exchanges failed, 0 for no limit")
+	flags.Var(&cfg.maxErrorRate, "max-error-rate", "Abort once the share of failed exchanges exceeds this, like 1%")
+	flags.StringVar(&cfg.compareURL, "compare-url", "", "Also post the same params as plain JSON to this HTTP endpoint and compare")
+	flags.BoolVar(&cfg.ui, "ui", false, "Show live stats every -interval, redrawn in place on a terminal")
+	flags.StringVar(&cfg.method, "method", "validate_session", "Method to call")
+	flags.StringVar(&cfg.schemaPath, "schema", "", "Generate params from this JSON schema file instead of validate_session ones")
+	flags.Int64Var(&cfg.seed, "seed", 1, "Seed for the generated params")
+	flags.IntVar(&cfg.variants, "variants", 1000, "Distinct requests generated from -schema to cycle through")
+	flags.IntVar(&cfg.cold, "cold", 0, "Only time the first exchange on each of n freshly dialed connections, including the connect")
+	flags.StringVar(&cfg.historyPath, "history", "", "Append the manifest and summary of the run to this JSONL file, like ~/.ucall-bench/history.jsonl")
+	flags.BoolVar(&cfg.compareLast, "compare-last", false, "Compare against the last run in -history with the same configuration")
+	flags.Var(&cfg.regressionThreshold, "regression", "Flag changes for the worse beyond this share in -compare-last, like 5%")
+	flags.BoolVar(&cfg.dryRun, "dry-run", false, "Print sample requests of this configuration as text and hex instead of connecting")
+	flags.BoolVar(&cfg.baseline, "baseline", false, "Measure an in-process loopback listener first and report it alongside the server")
+	flags.DurationVar(&cfg.readDelay, "read-delay", 0, "Pause before every read, simulating a slow consumer")
+	flags.IntVar(&cfg.readRate, "read-rate", 0, "Drain replies no faster than n bytes/second, simulating a slow consumer")
+	flags.StringVar(&cfg.jsonrpcVersion, "jsonrpc", "2.0", "Shape requests like JSON-RPC 1.0 or 2.0")
+	flags.StringVar(&cfg.checkpointPath, "checkpoint", "", "Periodically save the run's aggregates into this file, to be resumed after a crash")
+	flags.DurationVar(&cfg.checkpointEvery, "checkpoint-every", 10*time.Minute, "Interval between checkpoints")
+	flags.StringVar(&cfg.resumePath, "resume", "", "Merge the aggregates saved in this checkpoint into the run's")
+	flags.StringVar(&cfg.framing, "framing", "", "Send raw frames, http ones, or both to compare them in one run, defaults to -html")
+	flags.IntVar(&cfg.serverPID, "server-pid", 0, "Sample the CPU, memory, descriptors and context switches of this server process, Linux only")
+	flags.StringVar(&cfg.serverCmd, "server-cmd", "", "Launch the server with this shell command, sample it like -server-pid and stop it after the run")
+	flags.BoolVar(&cfg.repro, "repro", false, "Print the command line reproducing this run, with every flag and the bench build")
+	flags.StringVar(&cfg.scenario, "scenario", "", "Run a canned scenario instead, head-of-line mixes 1% large echoes into the traffic and compares the fast p99, kv gets and sets random keys")
+	flags.StringVar(&cfg.preset, "preset", "", "Run -scenario kv with the defaults of read-heavy, write-heavy or mixed traffic, explicit flags override them")
+	flags.IntVar(&cfg.keys, "keys", 10_000, "Distinct keys -scenario kv spreads its gets and sets over")
+	flags.IntVar(&cfg.valueSize, "value-size", 100, "Bytes in every value -scenario kv sets")
+	flags.Var(&cfg.readRatio, "read-ratio", "Share of gets among the -scenario kv exchanges, like 90%")
+	flags.StringVar(&cfg.localAddrs, "local-addrs", "", "Bind the connections to these comma-separated local IPs or interfaces in turns")
+	flags.BoolVar(&cfg.validate, "validate", false, "Check every -scenario kv reply, gets finding either nothing or the value set for the key")
+	flags.BoolVar(&cfg.verifyOrder, "verify-order", false, "Number every request and check each one is answered, reporting gaps")
+	cfg.servAddr, cfg.target = "localhost:8545", "/"
+	return cfg
+}
+
+// checkConfig refuses contradictory flags, exiting, and resolves the rest of the
+// configuration from them, with `explicit` naming the flags passed on the command line.
+func checkConfig(cfg *config, explicit map[string]bool) {
+	if cfg.verifyOrder && (cfg.schemaPath != "" || cfg.method != "validate_session") {
+		println("Order verification numbers validate_session requests, drop -schema and -method")
+		exit(1)
+	}
+	if cfg.jsonrpcVersion != "1.0" && cfg.jsonrpcVersion != "2.0" {
+		println("Only JSON-RPC 1.0 and 2.0 are supported")
+		exit(1)
+	}
+	if cfg.jsonrpcVersion == "1.0" && cfg.schemaPath != "" {
+		println("Schemas generate named params, which JSON-RPC 1.0 doesn't have")
+		exit(1)
+	}
+	if cfg.resumePath != "" && cfg.checkpointPath == "" {
+		println("Resuming needs a -checkpoint file to keep saving into, which may be the same one")
+		exit(1)
+	}
+	switch cfg.framing {
+	case "raw":
+		cfg.html = false
+	case "http":
+		cfg.html = true
+	case "", "both":
+	default:
+		println("Framing must be raw, http or both")
+		exit(1)
+	}
+	if cfg.preset != "" {
+		if err := applyPreset(cfg, cfg.preset, explicit); err != nil {
+			println("Invalid preset:", err.Error())
+			exit(1)
+		}
+	}
+	if cfg.scenario != "" && cfg.scenario != "head-of-line" && cfg.scenario != "kv" {
+		println("The scenarios are head-of-line and kv")
+		exit(1)
+	}
+	if cfg.scenario == "kv" && (cfg.keys <= 0 || cfg.valueSize <= 0 || cfg.readRatio < 0 || cfg.readRatio > 1) {
+		println("The kv scenario needs positive -keys and -value-size, and a -read-ratio within 0 and 100%")
+		exit(1)
+	}
+	if cfg.scenario == "kv" && cfg.jsonrpcVersion == "1.0" {
+		println("The kv scenario sends named params, which JSON-RPC 1.0 doesn't have")
+		exit(1)
+	}
+	if cfg.scenario != "" && (cfg.batch > 0 || cfg.framing == "both") {
+		println("Scenarios send single requests with one framing, drop -b and -framing both")
+		exit(1)
+	}
+	if cfg.serverCmd != "" && cfg.serverPID != 0 {
+		println("Pass either -server-pid or -server-cmd")
+		exit(1)
+	}
+	if cfg.serverCmd != "" && (cfg.cold > 0 || cfg.framing == "both" || cfg.dryRun || cfg.scenario != "") {
+		println("The server is only launched for the main run, not for -cold, -framing both, -scenario or -dry-run")
+		exit(1)
+	}
+	if cfg.localAddrs != "" {
+		var err error
+		if localSources, err = parseLocalAddrs(cfg.localAddrs); err != nil {
+			println("Invalid local addresses:", err.Error())
+			exit(1)
+		}
+	}
+	if cfg.compareLast && cfg.historyPath == "" {
+		println("Comparing against the last run needs a -history file")
+		exit(1)
+	}
+	if cfg.compareURL != "" && cfg.batch > 0 {
+		println("Batches have no plain HTTP/JSON equivalent, drop -b to compare")
+		exit(1)
+	}
+
+	cfg.servAddr = fmt.Sprintf(`localhost:%d`, cfg.port)
+	var err error
+	if cfg.proxyAddr, err = proxyAddress(cfg.proxy); err != nil {
+		println("Invalid proxy:", err.Error())
+		exit(1)
+	}
+	// Proxies expect the absolute URI of the resource in the request line.
+	cfg.target = "/"
+	if cfg.proxyAddr != "" {
+		cfg.target = "http://" + cfg.servAddr + "/"
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/unum-cloud/ucall/ucall-go/client"
+)
+
+// proxyFromEnvironment mirrors the conventional `HTTP_PROXY` lookup, as the
+// bench only ever talks plaintext to the server.
+func proxyFromEnvironment() string {
+	if value := os.Getenv("HTTP_PROXY"); value != "" {
+		return value
+	}
+	return os.Getenv("http_proxy")
+}
+
+// proxyAddress strips the optional scheme from a proxy setting, leaving `host:port`.
+func proxyAddress(setting string) (string, error) {
+	if !strings.Contains(setting, "://") {
+		return setting, nil
+	}
+	parsed, err := url.Parse(setting)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "http" {
+		return "", fmt.Errorf("unsupported proxy scheme %q", parsed.Scheme)
+	}
+	return parsed.Host, nil
+}
+
+// withoutCredentials drops the user and password from a proxy URL, which
+// neither the -history file nor the reproduction command should spread.
+func withoutCredentials(setting string) string {
+	parsed, err := url.Parse(setting)
+	if err != nil || parsed.User == nil || !strings.Contains(setting, "://") {
+		return setting
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+// isDrop reports whether the error means the connection is gone, which is how
+// a server or middlebox silently dropping an idle connection manifests on the
+// next exchange. Keepalive probes failing surface as `ETIMEDOUT`.
+func isDrop(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ETIMEDOUT)
+}
+
+// localSource is one of the -local-addrs, with the connections bound to it.
+type localSource struct {
+	name         string
+	ip           net.IP
+	connections  atomic.Int64
+	bindFailures atomic.Int64
+}
+
+// localSources are taken in turns by every dial, starting from localTurn.
+var localSources []*localSource
+
+var localTurn atomic.Uint64
+
+// parseLocalAddrs resolves the comma-separated -local-addrs, each being an IP or
+// the name of an interface, which stands for its first address, IPv4 if it has one.
+func parseLocalAddrs(value string) ([]*localSource, error) {
+	sources := []*localSource{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if ip := net.ParseIP(name); ip != nil {
+			sources = append(sources, &localSource{name: name, ip: ip})
+			continue
+		}
+		device, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an IP nor an interface", name)
+		}
+		addrs, err := device.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		var chosen net.IP
+		for _, addr := range addrs {
+			if network, isIP := addr.(*net.IPNet); isIP && (chosen == nil || (chosen.To4() == nil && network.IP.To4() != nil)) {
+				chosen = network.IP
+			}
+		}
+		if chosen == nil {
+			return nil, fmt.Errorf("interface %s has no address", name)
+		}
+		sources = append(sources, &localSource{name: name + " " + chosen.String(), ip: chosen})
+	}
+	return sources, nil
+}
+
+// isBindFailure tells errors binding the local address, like for an IP the machine
+// doesn't have or once its ephemeral ports run out, from failures to connect.
+func isBindFailure(err error) bool {
+	syscallErr := &os.SyscallError{}
+	return errors.As(err, &syscallErr) && syscallErr.Syscall == "bind"
+}
+
+// dialBound binds the connection to the next of the -local-addrs in turn, moving on
+// to the following ones if binding fails, so a single bad address doesn't stop the run.
+func dialBound(dialer net.Dialer, addr string) (net.Conn, error) {
+	if len(localSources) == 0 {
+		return dialer.Dial("tcp", addr)
+	}
+	turn := int(localTurn.Add(1) - 1)
+	var err error
+	for attempt := 0; attempt < len(localSources); attempt++ {
+		source := localSources[(turn+attempt)%len(localSources)]
+		dialer.LocalAddr = &net.TCPAddr{IP: source.ip}
+		var conn net.Conn
+		if conn, err = dialer.Dial("tcp", addr); err == nil {
+			source.connections.Add(1)
+			return conn, nil
+		}
+		if !isBindFailure(err) {
+			return nil, err
+		}
+		source.bindFailures.Add(1)
+	}
+	return nil, err
+}
+
+// printLocalSources reports how the connections were actually spread over the
+// -local-addrs, which bind failures skew from even turns.
+func printLocalSources() {
+	total := int64(0)
+	for _, source := range localSources {
+		total += source.connections.Load()
+	}
+	for _, source := range localSources {
+		connections := source.connections.Load()
+		fmt.Printf("Local address %s: %d connections (%.1f%%), %d bind failures\n",
+			source.name, connections, float64(connections)/float64(max(total, 1))*100, source.bindFailures.Load())
+	}
+}
+
+// dial connects to the server directly, or through the HTTP proxy if one is configured.
+// HTTP requests are forwarded by the proxy as is, as they carry an absolute-form target,
+// while raw JSON-RPC frames need a `CONNECT` tunnel.
+func dial(cfg *config) (net.Conn, error) {
+	dialer := net.Dialer{KeepAlive: cfg.keepAlive}
+	servAddr := cfg.servAddr
+	if cfg.proxyAddr == "" {
+		return dialBound(dialer, servAddr)
+	}
+	conn, err := dialBound(dialer, cfg.proxyAddr)
+	if err != nil || cfg.html {
+		return conn, err
+	}
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", servAddr, servAddr)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused the tunnel: %s", resp.Status)
+	}
+	return conn, nil
+}
+
+// newPool shares `connections` connections to the server between as many workers,
+// so none waits for one. They are dialed like all the others, through the proxy and
+// the -local-addrs.
+func newPool(cfg *config, connections int) *client.Pool {
+	return client.NewPool(cfg.servAddr, client.PoolOptions{
+		MaxIdle:   connections,
+		MaxActive: connections,
+		HTTP:      cfg.html,
+		Dial: func(context.Context, string) (net.Conn, error) {
+			return dial(cfg)
+		},
+	})
+}
+
+// poolSummary describes the connections a pool went through.
+func poolSummary(stats client.PoolStats) string {
+	return fmt.Sprintf("%d connections dialed, %d discarded, %d calls waited for one", stats.Dials, stats.Discarded, stats.Waits)
+}
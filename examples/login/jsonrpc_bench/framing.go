@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unum-cloud/ucall/ucall-go/client"
+)
+
+// legacyRequest is the JSON-RPC 1.0 envelope, which has no `jsonrpc` member.
+type legacyRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	ID     int             `json:"id"`
+}
+
+// envelope wraps the params into a request of the -jsonrpc version, escaping the
+// method like the client does for both.
+func envelope(cfg *config, method string, params string, id int) string {
+	if cfg.jsonrpcVersion == "1.0" {
+		buffer := bytes.Buffer{}
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetEscapeHTML(false)
+		// Params are always generated as valid JSON, so encoding can't fail
+		if err := encoder.Encode(legacyRequest{Method: method, Params: json.RawMessage(params), ID: id}); err != nil {
+			panic(err)
+		}
+		return strings.TrimSuffix(buffer.String(), "\n")
+	}
+	frame, err := client.Encode(method, json.RawMessage(params), id)
+	if err != nil {
+		panic(err)
+	}
+	return string(frame)
+}
+
+// batchEnvelope wraps every params into a request of the -jsonrpc version, with ids
+// counting up from `first`, and brackets them into a batch.
+func batchEnvelope(cfg *config, method string, params []string, first int) string {
+	if cfg.jsonrpcVersion == "1.0" {
+		requests := make([]string, len(params))
+		for i, each := range params {
+			requests[i] = envelope(cfg, method, each, first+i)
+		}
+		return "[" + strings.Join(requests, ",") + "]"
+	}
+	calls := make([]client.Request, len(params))
+	for i, each := range params {
+		calls[i] = client.Request{Method: method, Params: json.RawMessage(each)}
+	}
+	frame, err := client.EncodeBatch(calls, first)
+	if err != nil {
+		panic(err)
+	}
+	return string(frame)
+}
+
+// sessionParams renders `validate_session` params, positional for JSON-RPC 1.0
+// which has no named ones.
+func sessionParams(cfg *config, userID int, sessionID int) string {
+	if cfg.jsonrpcVersion == "1.0" {
+		return fmt.Sprintf(`[%d,%d]`, userID, sessionID)
+	}
+	return fmt.Sprintf(`{"user_id":%d,"session_id":%d}`, userID, sessionID)
+}
+
+// buildFrames renders the frames the send loop cycles through, returning the
+// bytes of params in each and the params of the first one as well. Without a
+// schema a single frame with random `validate_session` params is used.
+func buildFrames(cfg *config) (frames [][]byte, payloads []int, firstParams string, err error) {
+	random := rand.New(rand.NewSource(cfg.seed))
+	nextParams := func() string {
+		return sessionParams(cfg, random.Intn(1000), random.Intn(1000))
+	}
+	count := 1
+	if cfg.schemaPath != "" {
+		fields, err := loadSchema(cfg.schemaPath)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		count = cfg.variants
+		nextParams = func() string {
+			encoded, _ := json.Marshal(generateParams(fields, random))
+			return string(encoded)
+		}
+	}
+	for i := 0; i < count; i++ {
+		params, payload := make([]string, max(cfg.batch, 1)), 0
+		for j := range params {
+			params[j] = nextParams()
+			payload += len(params[j])
+		}
+		if i == 0 {
+			firstParams = params[0]
+		}
+		frame := envelope(cfg, cfg.method, params[0], 0)
+		if cfg.batch > 0 {
+			frame = batchEnvelope(cfg, cfg.method, params, 0)
+		}
+		if cfg.html {
+			frame = httpRequest(frame, cfg.servAddr, cfg.target)
+		}
+		frames = append(frames, []byte(frame))
+		payloads = append(payloads, payload)
+	}
+	return frames, payloads, firstParams, nil
+}
+
+func httpRequest(body string, host string, target string) string {
+	return fmt.Sprintf("POST %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: python-requests/2.31.0\r\nAccept-Encoding: gzip, deflate\r\nAccept: */*\r\nConnection: keep-alive\r\nContent-Length: %d\r\nContent-Type: application/json\r\n\r\n%s", target, host, len(body), body)
+}
+
+// sequenceFrame renders requests numbered from `first`, one or a batch of them.
+// The sequence number is both the `id` and the `user_id` with a zero
+// `session_id`, so every genuine reply is `true` exactly for multiples of 23.
+func sequenceFrame(cfg *config, first int) (frame []byte, payload int, count int) {
+	count = max(cfg.batch, 1)
+	params := make([]string, count)
+	for i := range params {
+		params[i] = sessionParams(cfg, first+i, 0)
+		payload += len(params[i])
+	}
+	body := envelope(cfg, "validate_session", params[0], first)
+	if cfg.batch > 0 {
+		body = batchEnvelope(cfg, "validate_session", params, first)
+	}
+	if cfg.html {
+		body = httpRequest(body, cfg.servAddr, cfg.target)
+	}
+	return []byte(body), payload, count
+}
+
+// readChunk is how many bytes to take per read, small enough for -read-rate to
+// pace them about a hundred times a second.
+func readChunk(readRate int) int {
+	if readRate > 0 {
+		return max(1, min(4096, readRate/100))
+	}
+	return 4096
+}
+
+// exchangeTiming marks the phases of one exchange, with the bytes its reply took
+// on the wire, HTTP head included.
+type exchangeTiming struct {
+	sent, written, firstByte, received time.Time
+	replyBytes                         int
+}
+
+// timedConn marks the phases of the exchanges made over it, reading like a slow
+// consumer would, pausing for -read-delay before every read and never exceeding
+// -read-rate.
+type timedConn struct {
+	net.Conn
+	timing    exchangeTiming
+	readDelay time.Duration
+	readRate  int
+}
+
+func (conn *timedConn) Write(frame []byte) (int, error) {
+	n, err := conn.Conn.Write(frame)
+	conn.timing.written = time.Now()
+	return n, err
+}
+
+func (conn *timedConn) Read(buffer []byte) (int, error) {
+	if conn.readDelay > 0 {
+		time.Sleep(conn.readDelay)
+	}
+	if conn.readRate > 0 {
+		buffer = buffer[:min(len(buffer), readChunk(conn.readRate))]
+	}
+	n, err := conn.Conn.Read(buffer)
+	if n > 0 && conn.timing.replyBytes == 0 {
+		conn.timing.firstByte = time.Now()
+	}
+	conn.timing.replyBytes += n
+	if conn.readRate > 0 {
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(conn.readRate))
+	}
+	return n, err
+}
+
+// timedClient makes the timed exchanges through a client.Client, over a timedConn.
+type timedClient struct {
+	*client.Client
+	conn *timedConn
+}
+
+// newTimedClient takes over the connection, framing replies like -html and
+// reading them like -read-delay and -read-rate.
+func newTimedClient(cfg *config, conn net.Conn) *timedClient {
+	timed := &timedConn{Conn: conn, readDelay: cfg.readDelay, readRate: cfg.readRate}
+	c := client.New(timed)
+	c.HTTP = cfg.html
+	return &timedClient{Client: c, conn: timed}
+}
+
+// exchange writes the frame and reads its whole reply, within the context, returning
+// the reply body. If the exchange failed, the timing tells whether any of the reply
+// arrived before, a connection closed without an error included.
+func (c *timedClient) exchange(ctx context.Context, frame []byte) (reply []byte, timing exchangeTiming, err error) {
+	c.conn.timing = exchangeTiming{sent: time.Now()}
+	reply, err = c.RoundTrip(ctx, frame)
+	timing = c.conn.timing
+	timing.received = time.Now()
+	return reply, timing, err
+}
+
+// printDryRun shows what the configured run would send, without connecting.
+func printDryRun(cfg *config, frames [][]byte) {
+	framing := "raw JSON-RPC frames"
+	if cfg.html {
+		framing = "JSON-RPC over HTTP/1.1"
+	}
+	fmt.Printf("Would send %s to %s, without TLS\n", framing, cfg.servAddr)
+	switch {
+	case cfg.proxyAddr != "" && cfg.html:
+		fmt.Printf("Through the proxy at %s, with absolute-form request targets\n", cfg.proxyAddr)
+	case cfg.proxyAddr != "":
+		fmt.Printf("Through a CONNECT tunnel at the proxy at %s\n", cfg.proxyAddr)
+	}
+	samples := frames
+	if cfg.verifyOrder {
+		samples = nil
+		for first := 0; len(samples) < 3; first += max(cfg.batch, 1) {
+			frame, _, _ := sequenceFrame(cfg, first)
+			samples = append(samples, frame)
+		}
+	}
+	if len(samples) > 3 {
+		fmt.Printf("Showing 3 of %d distinct frames, sent in a cycle\n", len(samples))
+		samples = samples[:3]
+	}
+	for i, frame := range samples {
+		fmt.Printf("\nFrame %d, %d bytes:\n%s\n%s", i, len(frame), strconv.Quote(string(frame)), hex.Dump(frame))
+	}
+	fmt.Println()
+	if cfg.verifyOrder {
+		fmt.Println("Every reply is read in full and must answer each numbered id once, with `true` exactly for multiples of 23")
+	} else {
+		fmt.Println("Replies are not validated, any whole reply completes the exchange")
+	}
+}
+
+// benchBaseline makes the same lockstep exchanges, through the same exchange function,
+// with an in-process listener echoing every frame back once it has fully arrived,
+// measuring the floor set by the client and the kernel alone. HTTP frames get their
+// body echoed in a reply, and the connection skips -local-addrs, as it stays local.
+func benchBaseline(cfg *config, frames [][]byte) (phaseStats, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	echoes := make([][]byte, len(frames))
+	for i, frame := range frames {
+		echoes[i] = frame
+		if head, body, _ := bytes.Cut(frame, []byte("\r\n\r\n")); bytes.HasPrefix(head, []byte("POST ")) {
+			echoes[i] = []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request := []byte{}
+				for i := 0; ; i++ {
+					request = append(request[:0], frames[i%len(frames)]...)
+					if _, err := io.ReadFull(conn, request); err != nil {
+						return
+					}
+					if _, err := conn.Write(echoes[i%len(frames)]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	c := newTimedClient(cfg, conn)
+	defer c.Close()
+	var took phaseStats
+	start := time.Now()
+	for len(took) < cfg.limitTransmits && time.Since(start) < baselineDuration {
+		_, timing, err := c.exchange(context.Background(), frames[len(took)%len(frames)])
+		if err != nil {
+			return nil, err
+		}
+		took = append(took, timing.received.Sub(timing.sent))
+	}
+	return took, nil
+}
+
+// framingResult summarizes one pass of -framing both.
+type framingResult struct {
+	took         phaseStats
+	failures     int
+	requestBytes int
+	replyBytes   int
+	elapsed      time.Duration
+}
+
+// benchFraming runs the same lockstep scenario with raw frames and then with
+// HTTP wrapping, sharing the params, limits and dialing.
+func benchFraming(cfg *config) error {
+	results := [2]framingResult{}
+	for pass, wrapped := range []bool{false, true} {
+		cfg := *cfg
+		cfg.html = wrapped
+		frames, _, _, err := buildFrames(&cfg)
+		if err != nil {
+			return err
+		}
+		result := &results[pass]
+		start := time.Now()
+		ctx, cancel := context.WithDeadline(context.Background(), start.Add(time.Duration(cfg.limitSeconds)*time.Second))
+		for len(result.took) < cfg.limitTransmits && time.Since(start).Seconds() < float64(cfg.limitSeconds) {
+			conn, err := dial(&cfg)
+			if err != nil {
+				cancel()
+				return err
+			}
+			c := newTimedClient(&cfg, conn)
+			for len(result.took) < cfg.limitTransmits && time.Since(start).Seconds() < float64(cfg.limitSeconds) {
+				frame := frames[len(result.took)%len(frames)]
+				_, timing, err := c.exchange(ctx, frame)
+				if err != nil {
+					// The exchange cut by the end of the pass isn't a failure
+					if !errors.Is(err, context.DeadlineExceeded) {
+						result.failures++
+					}
+					break
+				}
+				result.took = append(result.took, timing.received.Sub(timing.sent))
+				result.requestBytes += len(frame)
+				result.replyBytes += timing.replyBytes
+			}
+			c.Close()
+		}
+		cancel()
+		result.elapsed = time.Since(start)
+		sort.Slice(result.took, func(i, j int) bool { return result.took[i] < result.took[j] })
+	}
+	printFraming(cfg, results[0], results[1])
+	return nil
+}
+
+// printFraming compares the passes of -framing both, with "n/a" for whatever can't
+// be compared, like the latencies of a pass that got no replies at all.
+func printFraming(cfg *config, raw framingResult, http framingResult) {
+	cell := func(value float64) string {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return "n/a"
+		}
+		return strconv.FormatFloat(value, 'f', 1, 64)
+	}
+	row := func(name string, raw, http float64) {
+		change := "n/a"
+		if cost := (http - raw) / raw; !math.IsNaN(cost) && !math.IsInf(cost, 0) {
+			change = fmt.Sprintf("%+.1f%%", cost*100)
+		}
+		fmt.Printf("%-28s %14s %14s %10s\n", name, cell(raw), cell(http), change)
+	}
+	// Averages and percentiles over no exchanges are NaN, printed as "n/a"
+	mean := func(total int, took phaseStats) float64 {
+		if len(took) == 0 {
+			return math.NaN()
+		}
+		return float64(total) / float64(len(took))
+	}
+	micros := func(took phaseStats, share float64) float64 {
+		if len(took) == 0 {
+			return math.NaN()
+		}
+		return float64(percentile(took, share).Nanoseconds()) / 1e3
+	}
+	fmt.Printf("Same params, limits and connection, %s per framing:\n", time.Duration(cfg.limitSeconds)*time.Second)
+	fmt.Printf("%-28s %14s %14s %10s\n", "", "raw TCP", "HTTP/1.1", "HTTP cost")
+	// Every exchange carries a whole -b batch
+	requests := float64(max(cfg.batch, 1))
+	row("Requests/second", requests*float64(len(raw.took))/raw.elapsed.Seconds(), requests*float64(len(http.took))/http.elapsed.Seconds())
+	row("p50 latency, us", micros(raw.took, 0.5), micros(http.took, 0.5))
+	row("p99 latency, us", micros(raw.took, 0.99), micros(http.took, 0.99))
+	row("Request bytes", mean(raw.requestBytes, raw.took), mean(http.requestBytes, http.took))
+	row("Reply bytes", mean(raw.replyBytes, raw.took), mean(http.replyBytes, http.took))
+	fmt.Printf("Dropped connections: %d raw, %d HTTP\n", raw.failures, http.failures)
+}
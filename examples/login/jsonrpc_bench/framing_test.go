@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveReplies answers every request on the n-th accepted connection with the
+// next of the n-th replies, written in small pieces, closing it after the last.
+func serveReplies(t *testing.T, connections ...[]string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for _, replies := range connections {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			request := make([]byte, 64)
+			for _, reply := range replies {
+				if _, err := conn.Read(request); err != nil {
+					break
+				}
+				for len(reply) > 0 {
+					piece := min(len(reply), 1000)
+					conn.Write([]byte(reply[:piece]))
+					reply = reply[piece:]
+					time.Sleep(time.Millisecond)
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// Replies split over many reads are read whole through the client, leaving nothing
+// behind for the next exchange, while their wire bytes include the HTTP head.
+func TestExchangeReadsWholeReplies(t *testing.T) {
+	cfg := &config{}
+	large := `{"jsonrpc":"2.0","id":0,"result":"` + strings.Repeat(`[{\"`, 3000) + `"}`
+	batched := `[{"jsonrpc":"2.0","id":1,"result":true},{"jsonrpc":"2.0","id":2,"result":"}]"}]`
+	wrapped := "HTTP/1.1 200 OK\r\nContent-Length: 38\r\n\r\n" + `{"jsonrpc":"2.0","id":3,"result":true}`
+	addr := serveReplies(t, []string{large, batched}, []string{wrapped, wrapped})
+	for _, test := range []struct {
+		http    bool
+		replies []string
+		bodies  []string
+	}{
+		{false, []string{large, batched}, []string{large, batched}},
+		{true, []string{wrapped, wrapped}, []string{`{"jsonrpc":"2.0","id":3,"result":true}`, `{"jsonrpc":"2.0","id":3,"result":true}`}},
+	} {
+		cfg.html = test.http
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newTimedClient(cfg, conn)
+		for i, expected := range test.bodies {
+			reply, timing, err := c.exchange(context.Background(), []byte("{}"))
+			if err != nil || string(reply) != expected || timing.replyBytes != len(test.replies[i]) {
+				t.Fatalf("read %d of the %d bytes of the reply, %v", timing.replyBytes, len(test.replies[i]), err)
+			}
+			if timing.received.Before(timing.firstByte) || timing.firstByte.Before(timing.written) || timing.written.Before(timing.sent) {
+				t.Fatalf("the phases are out of order, %+v", timing)
+			}
+		}
+		c.Close()
+	}
+}
+
+// Replies cut short by the connection closing fail the exchange, telling whether any
+// of them arrived.
+func TestExchangeIncompleteReplies(t *testing.T) {
+	incomplete := []string{" ", `{"result":"}"`, `[{"a":1},`, `{"escaped":"\"}`}
+	connections := [][]string{{}}
+	for _, reply := range incomplete {
+		connections = append(connections, []string{reply})
+	}
+	addr := serveReplies(t, connections...)
+	for _, expected := range append([]string{""}, incomplete...) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newTimedClient(&config{}, conn)
+		reply, timing, err := c.exchange(context.Background(), []byte("{}"))
+		c.Close()
+		if err == nil || reply != nil || timing.replyBytes != len(expected) {
+			t.Fatalf("%q was read as %q, %d bytes arrived, %v", expected, reply, timing.replyBytes, err)
+		}
+	}
+}
+
+// The baseline completes exchanges of either framing, without binding to -local-addrs.
+func TestBaseline(t *testing.T) {
+	cfg := defineFlags(flag.NewFlagSet("bench", flag.ContinueOnError))
+	localSources = []*localSource{{name: "127.0.0.2", ip: net.ParseIP("127.0.0.2")}}
+	defer func() { localSources = nil }()
+	cfg.limitTransmits = 100
+	for _, wrapped := range []bool{false, true} {
+		cfg.html = wrapped
+		frames, _, _, err := buildFrames(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		took, err := benchBaseline(cfg, frames)
+		if err != nil || len(took) != cfg.limitTransmits {
+			t.Fatalf("with html %t the baseline made %d exchanges, %v", wrapped, len(took), err)
+		}
+	}
+	if connections := localSources[0].connections.Load(); connections != 0 {
+		t.Fatalf("the baseline bound %d connections to -local-addrs", connections)
+	}
+}
+
+// captureStdout returns what the function printed.
+func captureStdout(t *testing.T, print func()) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(was *os.File) { os.Stdout = was }(os.Stdout)
+	os.Stdout = writer
+	printed := make(chan string)
+	go func() {
+		content, _ := io.ReadAll(reader)
+		printed <- string(content)
+	}()
+	print()
+	writer.Close()
+	return <-printed
+}
+
+// Only connections breaking within the limits count as dropped, and passes without
+// replies print "n/a" rather than NaN.
+func TestFraming(t *testing.T) {
+	cfg := defineFlags(flag.NewFlagSet("bench", flag.ContinueOnError))
+	cfg.limitTransmits = 20
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// The first connection of either framing breaks after a few exchanges
+	broken := map[bool]*atomic.Bool{false: {}, true: {}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request := make([]byte, 4096)
+				breaks := false
+				for exchanges := 0; !breaks || exchanges < 5; exchanges++ {
+					n, err := conn.Read(request)
+					if err != nil {
+						return
+					}
+					wrapped := bytes.HasPrefix(request[:n], []byte("POST "))
+					if exchanges == 0 {
+						breaks = !broken[wrapped].Swap(true)
+					}
+					reply := `{"jsonrpc":"2.0","id":0,"result":true}`
+					if wrapped {
+						reply = fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(reply), reply)
+					}
+					conn.Write([]byte(reply))
+				}
+			}()
+		}
+	}()
+	printed := captureStdout(t, func() {
+		cfg.servAddr = listener.Addr().String()
+		if err := benchFraming(cfg); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(printed, "Dropped connections: 1 raw, 1 HTTP") || strings.Contains(printed, "n/a") {
+		t.Fatalf("the framings compared as\n%s", printed)
+	}
+
+	printed = captureStdout(t, func() {
+		printFraming(cfg, framingResult{took: phaseStats{time.Millisecond}, requestBytes: 100, replyBytes: 40, elapsed: time.Second},
+			framingResult{elapsed: time.Second})
+	})
+	if strings.Contains(printed, "NaN") || strings.Contains(printed, "Inf") || strings.Count(printed, "n/a") != 8 {
+		t.Fatalf("a pass without replies compared as\n%s", printed)
+	}
+}
+
+// Batches are JSON arrays of distinct ids, wrapped into HTTP like single requests.
+func TestBuildFramesBatch(t *testing.T) {
+	cfg := defineFlags(flag.NewFlagSet("bench", flag.ContinueOnError))
+	cfg.batch = 3
+	for _, wrapped := range []bool{false, true} {
+		cfg.html = wrapped
+		frames, payloads, firstParams, err := buildFrames(cfg)
+		if err != nil || len(frames) != 1 {
+			t.Fatalf("with html %t built %d frames, %v", wrapped, len(frames), err)
+		}
+		body := frames[0]
+		if wrapped {
+			head, rest, found := bytes.Cut(body, []byte("\r\n\r\n"))
+			if !found || !bytes.HasPrefix(head, []byte("POST / HTTP/1.1")) || !bytes.Contains(head, []byte(fmt.Sprintf("Content-Length: %d", len(rest)))) {
+				t.Fatalf("the batch was wrapped as %q", body)
+			}
+			body = rest
+		}
+		requests := []struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			ID     int             `json:"id"`
+		}{}
+		if err := json.Unmarshal(body, &requests); err != nil || len(requests) != cfg.batch {
+			t.Fatalf("with html %t the batch %q doesn't decode into %d requests, %v", wrapped, body, cfg.batch, err)
+		}
+		payload := 0
+		for i, request := range requests {
+			if request.ID != i || request.Method != "validate_session" {
+				t.Fatalf("with html %t the request %d is %+v", wrapped, i, request)
+			}
+			payload += len(request.Params)
+		}
+		if payloads[0] != payload || string(requests[0].Params) != firstParams {
+			t.Fatalf("with html %t counted %d bytes of params from %s", wrapped, payloads[0], firstParams)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	cfg := defineFlags(flag.NewFlagSet("bench", flag.ContinueOnError))
+	for _, test := range []struct {
+		version, method, expected string
+	}{
+		{"2.0", "validate_session", `{"jsonrpc":"2.0","method":"validate_session","params":{"user_id":2},"id":7}`},
+		{"1.0", "validate_session", `{"method":"validate_session","params":{"user_id":2},"id":7}`},
+		// Methods are escaped in both versions, leaving `<` and `&` as they are
+		{"2.0", `say "hi" <&>`, `{"jsonrpc":"2.0","method":"say \"hi\" <&>","params":{"user_id":2},"id":7}`},
+		{"1.0", `say "hi" <&>`, `{"method":"say \"hi\" <&>","params":{"user_id":2},"id":7}`},
+	} {
+		cfg.jsonrpcVersion = test.version
+		if frame := envelope(cfg, test.method, `{"user_id":2}`, 7); frame != test.expected {
+			t.Fatalf("%s %q was wrapped as %s", test.version, test.method, frame)
+		}
+	}
+}
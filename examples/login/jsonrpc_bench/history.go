@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// clientVersion describes the build of this bench, for the reproduction command.
+func clientVersion() string {
+	if buildVersion != "" {
+		return buildVersion
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "unknown " + info.GoVersion
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return revision + " " + info.GoVersion
+}
+
+// shellQuote leaves plain words as they are and single-quotes everything else.
+func shellQuote(word string) string {
+	plain := word != ""
+	for _, c := range word {
+		if !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-+=.,:/@%", c) {
+			plain = false
+		}
+	}
+	if plain {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// recordedValue is the value of the flag as the history and the reproduction
+// command keep it, the -proxy without its credentials.
+func recordedValue(f *flag.Flag) string {
+	if f.Name == "proxy" {
+		return withoutCredentials(f.Value.String())
+	}
+	return f.Value.String()
+}
+
+// reproCommand spells out every flag, including the defaults, so the run can be
+// repeated exactly even if the defaults change, followed by the bench build.
+func reproCommand() string {
+	words := []string{"go", "run", "./examples/login/jsonrpc_bench"}
+	flag.VisitAll(func(f *flag.Flag) {
+		words = append(words, shellQuote("-"+f.Name+"="+recordedValue(f)))
+	})
+	return strings.Join(words, " ") + " # built from " + clientVersion()
+}
+
+// historyEntry is one line of the -history file, the manifest and summary of a run.
+type historyEntry struct {
+	Time        time.Time         `json:"time"`
+	Fingerprint string            `json:"fingerprint"`
+	Flags       map[string]string `json:"flags"`
+	Requests    int               `json:"requests"`
+	Throughput  float64           `json:"throughput"`
+	P50         float64           `json:"p50_us"`
+	P99         float64           `json:"p99_us"`
+	ErrorRate   float64           `json:"error_rate"`
+	Repro       string            `json:"repro"`
+}
+
+// runManifest lists every flag and fingerprints the ones defining the load, so
+// runs only differing in their length or reporting can be compared.
+func runManifest() (flags map[string]string, fingerprint string) {
+	reporting := map[string]bool{"s": true, "n": true, "history": true, "compare-last": true, "regression": true,
+		"ui": true, "per-conn-csv": true, "interval": true, "max-errors": true, "max-error-rate": true,
+		"server-pid": true, "repro": true}
+	flags = map[string]string{}
+	hash := fnv.New64a()
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = recordedValue(f)
+		if !reporting[f.Name] {
+			fmt.Fprintf(hash, "%s=%s\n", f.Name, flags[f.Name])
+		}
+	})
+	return flags, fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// recordHistory appends the run to -history with its manifest, comparing it to
+// the last one alike for -compare-last.
+func recordHistory(cfg *config, exchangeTimes *latencyHistogram, requests int, throughput float64, failures int) {
+	flags, fingerprint := runManifest()
+	// A run without a single attempt has no error rate, and JSON has no NaN
+	errorRate := 0.0
+	if requests+failures > 0 {
+		errorRate = float64(failures) / float64(requests+failures)
+	}
+	current := historyEntry{
+		Time:        time.Now(),
+		Fingerprint: fingerprint,
+		Flags:       flags,
+		Requests:    requests,
+		Throughput:  throughput,
+		P50:         float64(exchangeTimes.percentile(0.5).Nanoseconds()) / 1e3,
+		P99:         float64(exchangeTimes.percentile(0.99).Nanoseconds()) / 1e3,
+		ErrorRate:   errorRate,
+		Repro:       reproCommand(),
+	}
+	last, err := appendHistory(cfg.historyPath, current)
+	switch {
+	case err != nil:
+		println("Updating the history failed:", err.Error())
+	case cfg.compareLast && last == nil:
+		fmt.Printf("\nNo earlier run with configuration %s to compare against\n", fingerprint)
+	case cfg.compareLast:
+		printDelta(*last, current, float64(cfg.regressionThreshold))
+	}
+}
+
+// appendHistory records the run, returning the latest earlier one with the same fingerprint.
+func appendHistory(path string, entry historyEntry) (last *historyEntry, err error) {
+	if rest, found := strings.CutPrefix(path, "~/"); found {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = home + "/" + rest
+	}
+	if slash := strings.LastIndex(path, "/"); slash > 0 {
+		if err := os.MkdirAll(path[:slash], 0o755); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		previous := historyEntry{}
+		if json.Unmarshal(scanner.Bytes(), &previous) == nil && previous.Fingerprint == entry.Fingerprint {
+			last = &previous
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return last, err
+}
+
+// printDelta compares a run against an earlier one, flagging changes for the
+// worse beyond the threshold share.
+func printDelta(last historyEntry, current historyEntry, threshold float64) {
+	fmt.Printf("\nCompared to the last matching run from %s:\n", last.Time.Format(time.RFC3339))
+	row := func(name string, before, after float64, higherIsBetter bool) {
+		change := 0.0
+		if before != 0 {
+			change = (after - before) / before
+		}
+		worse := change < -threshold
+		if !higherIsBetter {
+			worse = change > threshold || (before == 0 && after > 0)
+		}
+		marker := ""
+		if worse {
+			marker = "  REGRESSION"
+		}
+		fmt.Printf("%-22s %14.4g %14.4g %+9.1f%%%s\n", name, before, after, change*100, marker)
+	}
+	row("Commands/second", last.Throughput, current.Throughput, true)
+	row("p50 latency, us", last.P50, current.P50, false)
+	row("p99 latency, us", last.P99, current.P99, false)
+	row("Error rate", last.ErrorRate, current.ErrorRate, false)
+}
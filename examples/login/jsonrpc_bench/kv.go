@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unum-cloud/ucall/ucall-go/client"
+)
+
+// kvConnections run -scenario kv concurrently, each over its own share of the keys.
+const kvConnections = 8
+
+// kvPreset is the effective kv configuration a -preset stands for.
+type kvPreset struct {
+	keys      int
+	valueSize int
+	readRatio percent
+	validate  bool
+}
+
+// kvPresets are documented in the README, which should be kept in sync.
+var kvPresets = map[string]kvPreset{
+	"read-heavy":  {keys: 100_000, valueSize: 100, readRatio: 0.95, validate: true},
+	"write-heavy": {keys: 100_000, valueSize: 1024, readRatio: 0.1, validate: true},
+	"mixed":       {keys: 100_000, valueSize: 256, readRatio: 0.5, validate: true},
+}
+
+// applyPreset selects -scenario kv with the defaults of the preset, except for the
+// `explicit` flags passed on the command line, which always win.
+func applyPreset(cfg *config, name string, explicit map[string]bool) error {
+	preset, known := kvPresets[name]
+	if !known {
+		return fmt.Errorf("unknown preset %q, pick read-heavy, write-heavy or mixed", name)
+	}
+	if explicit["scenario"] && cfg.scenario != "kv" {
+		return fmt.Errorf("presets configure -scenario kv, not %s", cfg.scenario)
+	}
+	cfg.scenario = "kv"
+	if !explicit["keys"] {
+		cfg.keys = preset.keys
+	}
+	if !explicit["value-size"] {
+		cfg.valueSize = preset.valueSize
+	}
+	if !explicit["read-ratio"] {
+		cfg.readRatio = preset.readRatio
+	}
+	if !explicit["validate"] {
+		cfg.validate = preset.validate
+	}
+	return nil
+}
+
+// kvValue is the only value ever set for the key, so concurrent connections can't
+// make a get ambiguous: it finds either nothing yet or exactly this. Keys are named
+// after the value size too, as the store may keep values of earlier runs.
+func kvValue(key int, padding string) string {
+	value := fmt.Sprintf("value-%d-", key)
+	if len(value) >= len(padding) {
+		return value[:len(padding)]
+	}
+	return value + padding[len(value):]
+}
+
+// kvReplyValid checks the reply of a get or a set, with the `expected` value for gets,
+// also reporting whether a get found its key.
+func kvReplyValid(reply []byte, isGet bool, expected string) (valid bool, found bool) {
+	if head, rest, found := bytes.Cut(reply, []byte("\r\n\r\n")); found && bytes.HasPrefix(head, []byte("HTTP/")) {
+		reply = rest
+	}
+	decoded := struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}{}
+	if json.Unmarshal(reply, &decoded) != nil || decoded.Error != nil || decoded.Result == nil {
+		return false, false
+	}
+	if !isGet || string(decoded.Result) == "null" {
+		return true, false
+	}
+	value := ""
+	valid = json.Unmarshal(decoded.Result, &value) == nil && value == expected
+	return valid, valid
+}
+
+// kvResult collects the exchanges of -scenario kv.
+type kvResult struct {
+	sync.Mutex
+	gets, sets phaseStats
+	drops      int
+	invalid    int
+	hits       int
+	pool       client.PoolStats
+}
+
+// benchKV gets and sets random keys of a -keys wide keyspace over a pool of a few
+// connections, for as long as -s lasts, checking the replies with -validate.
+// Exchanges are timed around the round trips of the pool, checking out included.
+func benchKV(cfg *config) (*kvResult, time.Duration) {
+	padding := strings.Repeat("x", cfg.valueSize)
+	frame := func(method string, params string) []byte {
+		body := envelope(cfg, method, params, 0)
+		if cfg.html {
+			return []byte(httpRequest(body, cfg.servAddr, cfg.target))
+		}
+		return []byte(body)
+	}
+
+	result := &kvResult{}
+	pool := newPool(cfg, kvConnections)
+	defer pool.Close()
+	start := time.Now()
+	deadline := start.Add(time.Duration(cfg.limitSeconds) * time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	workers := sync.WaitGroup{}
+	for worker := 0; worker < kvConnections; worker++ {
+		workers.Add(1)
+		go func(random *rand.Rand) {
+			defer workers.Done()
+			gets, sets, drops, invalid, hits := phaseStats{}, phaseStats{}, 0, 0, 0
+			for time.Now().Before(deadline) {
+				key := random.Intn(cfg.keys)
+				isGet := random.Float64() < float64(cfg.readRatio)
+				value := kvValue(key, padding)
+				request := frame("set", fmt.Sprintf(`{"key":"key-%d-%d","value":"%s"}`, cfg.valueSize, key, value))
+				if isGet {
+					request = frame("get", fmt.Sprintf(`{"key":"key-%d-%d"}`, cfg.valueSize, key))
+				}
+				sent := time.Now()
+				reply, err := pool.RoundTrip(ctx, request)
+				if err != nil {
+					if time.Now().Before(deadline) {
+						drops++
+						// Failures to connect wait a little before the next dial
+						if !isDrop(err) {
+							time.Sleep(10 * time.Millisecond)
+						}
+					}
+					continue
+				}
+				took := time.Since(sent)
+				if isGet {
+					gets = append(gets, took)
+				} else {
+					sets = append(sets, took)
+				}
+				if cfg.validate {
+					valid, found := kvReplyValid(reply, isGet, value)
+					if !valid {
+						invalid++
+					}
+					if found {
+						hits++
+					}
+				}
+			}
+			result.Lock()
+			result.gets = append(result.gets, gets...)
+			result.sets = append(result.sets, sets...)
+			result.drops += drops
+			result.invalid += invalid
+			result.hits += hits
+			result.Unlock()
+		}(rand.New(rand.NewSource(cfg.seed + int64(worker))))
+	}
+	workers.Wait()
+	result.pool = pool.Stats()
+	sort.Slice(result.gets, func(i, j int) bool { return result.gets[i] < result.gets[j] })
+	sort.Slice(result.sets, func(i, j int) bool { return result.sets[i] < result.sets[j] })
+	return result, time.Since(start)
+}
+
+// printKV summarizes -scenario kv, returning all the exchanges for the history.
+func printKV(cfg *config, result *kvResult, elapsed time.Duration) *latencyHistogram {
+	exchanges := len(result.gets) + len(result.sets)
+	fmt.Printf("Key-value traffic, %d connections over %d keys, %d byte values, %.0f%% gets:\n",
+		kvConnections, cfg.keys, cfg.valueSize, float64(cfg.readRatio)*100)
+	fmt.Printf("Gets: %d, %s\n", len(result.gets), result.gets)
+	fmt.Printf("Sets: %d, %s\n", len(result.sets), result.sets)
+	fmt.Printf("Throughput: %.1f exchanges/s over %s, %d dropped connections\n",
+		float64(exchanges)/elapsed.Seconds(), elapsed.Truncate(time.Millisecond), result.drops)
+	fmt.Printf("Pool: %s\n", poolSummary(result.pool))
+	if cfg.validate {
+		fmt.Printf("Validation: every reply is checked, %d invalid, %d of %d gets found their key\n",
+			result.invalid, result.hits, len(result.gets))
+	} else {
+		fmt.Printf("Validation: replies are only read to their end, -validate checks them\n")
+	}
+	all := &latencyHistogram{}
+	for _, took := range append(append(phaseStats{}, result.gets...), result.sets...) {
+		all.add(took)
+	}
+	return all
+}
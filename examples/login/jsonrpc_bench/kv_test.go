@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// readmePresets parses the table of presets in the README, with rows like
+// "| `mixed` | 100,000 | 256 B | 50% | on |".
+func readmePresets(t *testing.T) map[string]kvPreset {
+	readme, err := os.ReadFile("../README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	presets := map[string]kvPreset{}
+	for _, line := range strings.Split(string(readme), "\n") {
+		if !strings.HasPrefix(line, "| `") {
+			continue
+		}
+		cells := strings.Split(strings.Trim(line, "| "), "|")
+		for i := range cells {
+			cells[i] = strings.TrimSpace(strings.NewReplacer(",", "", "`", "", " B", "").Replace(cells[i]))
+		}
+		if len(cells) != 5 {
+			t.Fatalf("the preset row %q has %d cells", line, len(cells))
+		}
+		preset := kvPreset{validate: cells[4] == "on"}
+		_, errKeys := fmt.Sscan(cells[1], &preset.keys)
+		_, errSize := fmt.Sscan(cells[2], &preset.valueSize)
+		if err := errors.Join(errKeys, errSize, preset.readRatio.Set(cells[3])); err != nil {
+			t.Fatalf("the preset row %q doesn't parse: %v", line, err)
+		}
+		presets[cells[0]] = preset
+	}
+	return presets
+}
+
+// The effective configurations are the ones the README documents for every preset.
+func TestPresets(t *testing.T) {
+	documented := readmePresets(t)
+	for name, expected := range documented {
+		cfg := &config{keys: 1, valueSize: 1}
+		if err := applyPreset(cfg, name, nil); err != nil {
+			t.Fatal(err)
+		}
+		effective := kvPreset{keys: cfg.keys, valueSize: cfg.valueSize, readRatio: cfg.readRatio, validate: cfg.validate}
+		if cfg.scenario != "kv" || effective != expected {
+			t.Fatalf("%s configures -scenario %q with %+v, the README documents %+v", name, cfg.scenario, effective, expected)
+		}
+	}
+	for name := range kvPresets {
+		if _, found := documented[name]; !found {
+			t.Fatalf("the preset %s is missing from the README", name)
+		}
+	}
+
+	// Explicit flags win over the preset
+	cfg := &config{scenario: "kv", keys: 7, valueSize: 1}
+	if err := applyPreset(cfg, "read-heavy", map[string]bool{"scenario": true, "keys": true, "validate": true}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.keys != 7 || cfg.validate || cfg.valueSize != 100 || cfg.readRatio != 0.95 {
+		t.Fatalf("explicit flags were overridden, keys %d, validate %t", cfg.keys, cfg.validate)
+	}
+	cfg.scenario = "head-of-line"
+	if applyPreset(cfg, "mixed", map[string]bool{"scenario": true}) == nil {
+		t.Fatal("a preset replaced an explicit -scenario head-of-line")
+	}
+	if applyPreset(cfg, "read-mostly", nil) == nil {
+		t.Fatal("an unknown preset was accepted")
+	}
+}
+
+func TestKVReplyValid(t *testing.T) {
+	value := kvValue(42, "xxxxxxxxxxxxxxxx")
+	if value != "value-42-xxxxxxx" {
+		t.Fatalf("the value of the key 42 is %q", value)
+	}
+	for _, test := range []struct {
+		reply        string
+		isGet        bool
+		valid, found bool
+	}{
+		{`{"jsonrpc":"2.0","id":0,"result":"OK"}`, false, true, false},
+		{`{"jsonrpc":"2.0","id":0,"result":null}`, true, true, false},
+		{`{"jsonrpc":"2.0","id":0,"result":"value-42-xxxxxxx"}`, true, true, true},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 50\r\n\r\n" + `{"jsonrpc":"2.0","id":0,"result":"value-42-xxxxxxx"}`, true, true, true},
+		{`{"jsonrpc":"2.0","id":0,"result":"value-24-xxxxxxx"}`, true, false, false},
+		{`{"jsonrpc":"2.0","id":0,"error":{"code":-32602,"message":"Invalid method param(s)."}}`, false, false, false},
+		{`{"jsonrpc":"2.0","id":0,"result":OK}`, false, false, false},
+	} {
+		valid, found := kvReplyValid([]byte(test.reply), test.isGet, value)
+		if valid != test.valid || found != test.found {
+			t.Fatalf("%q is valid %t and found %t", test.reply, valid, found)
+		}
+	}
+}
+
+// serveKV answers raw `get` and `set` calls from one store, closing every connection
+// after `perConnection` replies, like a server with a keep-alive request limit.
+func serveKV(t *testing.T, perConnection int) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	store := sync.Map{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				decoder, encoder := json.NewDecoder(conn), json.NewEncoder(conn)
+				for i := 0; i < perConnection; i++ {
+					request := struct {
+						Method string `json:"method"`
+						Params struct {
+							Key   string `json:"key"`
+							Value string `json:"value"`
+						} `json:"params"`
+						ID int `json:"id"`
+					}{}
+					if decoder.Decode(&request) != nil {
+						return
+					}
+					var result any = "OK"
+					if request.Method == "get" {
+						result, _ = store.Load(request.Params.Key)
+					} else {
+						store.Store(request.Params.Key, request.Params.Value)
+					}
+					encoder.Encode(map[string]any{"jsonrpc": "2.0", "id": request.ID, "result": result})
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestBenchKV(t *testing.T) {
+	cfg := defineFlags(flag.NewFlagSet("bench", flag.ContinueOnError))
+	cfg.servAddr = serveKV(t, 100)
+	cfg.limitSeconds, cfg.keys, cfg.valueSize, cfg.readRatio, cfg.validate = 1, 16, 32, 0.5, true
+	result, _ := benchKV(cfg)
+	exchanges := len(result.gets) + len(result.sets)
+	if exchanges == 0 || result.invalid != 0 || result.hits == 0 {
+		t.Fatalf("%d exchanges got %d invalid replies and %d hits", exchanges, result.invalid, result.hits)
+	}
+	// Every connection the server closed is dialed again by the pool, which never
+	// runs out of them with one per worker
+	if stats := result.pool; result.drops == 0 || stats.Waits != 0 || stats.Dials < exchanges/100 || stats.Discarded < result.drops || stats.Active != 0 {
+		t.Fatalf("%d exchanges with %d drops went through the pool as %+v", exchanges, result.drops, stats)
+	}
+}
+
+// The connections the pool dials again take the -local-addrs in turns too.
+func TestBenchKVLocalAddrs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only Linux routes all of 127.0.0.0/8 to the loopback")
+	}
+	cfg := defineFlags(flag.NewFlagSet("bench", flag.ContinueOnError))
+	cfg.servAddr = serveKV(t, 10)
+	cfg.limitSeconds, cfg.keys, cfg.valueSize, cfg.readRatio = 1, 16, 32, 0.5
+	var err error
+	localSources, err = parseLocalAddrs("127.0.0.2,127.0.0.3")
+	defer func() { localSources = nil }()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := benchKV(cfg)
+	first, second := localSources[0].connections.Load(), localSources[1].connections.Load()
+	if first+second != int64(result.pool.Dials) || first-second > 1 || second-first > 1 || result.pool.Dials <= kvConnections {
+		t.Fatalf("%d connections were dialed over %d and %d", result.pool.Dials, first, second)
+	}
+}
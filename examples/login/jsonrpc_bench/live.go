@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// timelineRow aggregates the exchanges of one connection within one interval.
+type timelineRow struct {
+	connection int
+	start      time.Duration
+	requests   int
+	errors     int
+	total      time.Duration
+	slowest    time.Duration
+}
+
+func (row *timelineRow) add(took time.Duration) {
+	row.requests++
+	row.total += took
+	if took > row.slowest {
+		row.slowest = took
+	}
+}
+
+// writeTimeline drains the rows into a CSV file off the hot path, closing
+// `done` once the channel is closed and everything is flushed.
+func writeTimeline(path string, rows <-chan timelineRow, done chan<- error) {
+	file, err := os.Create(path)
+	if err != nil {
+		for range rows {
+		}
+		done <- err
+		return
+	}
+	buffered := bufio.NewWriter(file)
+	writer := csv.NewWriter(buffered)
+	writer.Write([]string{"connection", "interval_start_seconds", "requests", "errors", "mean_latency_us", "max_latency_us"})
+	for row := range rows {
+		mean := 0.0
+		if row.requests > 0 {
+			mean = float64(row.total.Microseconds()) / float64(row.requests)
+		}
+		writer.Write([]string{
+			strconv.Itoa(row.connection),
+			strconv.FormatFloat(row.start.Seconds(), 'f', 3, 64),
+			strconv.Itoa(row.requests),
+			strconv.Itoa(row.errors),
+			strconv.FormatFloat(mean, 'f', 1, 64),
+			strconv.FormatInt(row.slowest.Microseconds(), 10),
+		})
+	}
+	writer.Flush()
+	err = errors.Join(writer.Error(), buffered.Flush(), file.Close())
+	done <- err
+}
+
+// liveStats is shared between the send loop and the `-ui` renderer.
+type liveStats struct {
+	sync.Mutex
+	requests  int
+	errors    int
+	latencies []time.Duration
+}
+
+func (live *liveStats) record(took time.Duration) {
+	live.Lock()
+	live.requests++
+	live.latencies = append(live.latencies, took)
+	live.Unlock()
+}
+
+func (live *liveStats) fail() {
+	live.Lock()
+	live.errors++
+	live.Unlock()
+}
+
+// drain returns the counters accumulated since the previous call and resets them.
+func (live *liveStats) drain() (requests int, errors int, latencies []time.Duration) {
+	live.Lock()
+	defer live.Unlock()
+	requests, errors, latencies = live.requests, live.errors, live.latencies
+	live.requests, live.errors, live.latencies = 0, 0, make([]time.Duration, 0, len(latencies))
+	return
+}
+
+// percentile expects the latencies to be sorted.
+func percentile(latencies []time.Duration, share float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	return latencies[int(float64(len(latencies)-1)*share)]
+}
+
+// sparkline renders the values as bars scaled to the largest of them.
+func sparkline(values []float64) string {
+	bars := []rune("▁▂▃▄▅▆▇█")
+	peak := 0.0
+	for _, value := range values {
+		if value > peak {
+			peak = value
+		}
+	}
+	line := make([]rune, len(values))
+	for i, value := range values {
+		level := 0
+		if peak > 0 {
+			level = int(value / peak * float64(len(bars)-1))
+		}
+		line[i] = bars[level]
+	}
+	return string(line)
+}
+
+// renderLive prints the stats of every -interval until `stop` is closed. On a terminal
+// it keeps redrawing a single line with a throughput sparkline, elsewhere it appends lines.
+func renderLive(cfg *config, live *liveStats, start time.Time, stop <-chan struct{}, done chan<- struct{}) {
+	const historyLength = 40
+	info, err := os.Stdout.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	history := []float64{}
+	failures := 0
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			if tty {
+				fmt.Println()
+			}
+			return
+		case <-ticker.C:
+		}
+
+		requests, failed, latencies := live.drain()
+		failures += failed
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		qps := float64(requests) / cfg.interval.Seconds()
+		history = append(history, qps)
+		if len(history) > historyLength {
+			history = history[1:]
+		}
+		elapsed := time.Since(start).Truncate(time.Second)
+		line := fmt.Sprintf("%s/%ds  %.0f QPS  p50 %s  p99 %s  %d errors",
+			elapsed, cfg.limitSeconds, qps, percentile(latencies, 0.5), percentile(latencies, 0.99), failures)
+		if tty {
+			fmt.Printf("\r\033[K%s  %s", line, sparkline(history))
+		} else {
+			fmt.Println(line)
+		}
+	}
+}
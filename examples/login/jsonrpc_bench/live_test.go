@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTimeline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timeline.csv")
+	rows, done := make(chan timelineRow), make(chan error)
+	go writeTimeline(path, rows, done)
+
+	busy := timelineRow{connection: 0, start: 1500 * time.Millisecond}
+	busy.add(100 * time.Microsecond)
+	busy.add(300 * time.Microsecond)
+	rows <- busy
+	rows <- timelineRow{connection: 1, start: 2 * time.Second, errors: 3}
+	close(rows)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"connection,interval_start_seconds,requests,errors,mean_latency_us,max_latency_us",
+		"0,1.500,2,0,200.0,300",
+		// Intervals with only errors have no latencies to average
+		"1,2.000,0,3,0.0,0",
+	}, "\n") + "\n"
+	if string(content) != expected {
+		t.Fatalf("the timeline was written as:\n%s", content)
+	}
+
+	// An unwritable path still drains the rows, so the send loop never blocks
+	rows = make(chan timelineRow)
+	go writeTimeline(filepath.Join(t.TempDir(), "missing", "timeline.csv"), rows, done)
+	rows <- busy
+	close(rows)
+	if err := <-done; err == nil {
+		t.Fatal("writing into a missing directory succeeded")
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	for _, test := range []struct {
+		values   []float64
+		expected string
+	}{
+		{nil, ""},
+		{[]float64{0, 0, 0}, "▁▁▁"},
+		{[]float64{5}, "█"},
+		// Bars are scaled to the peak, rounding down
+		{[]float64{0, 1, 2, 3, 4, 5, 6, 7}, "▁▂▃▄▅▆▇█"},
+		{[]float64{700, 100, 350, 699}, "█▂▄▇"},
+	} {
+		if line := sparkline(test.values); line != test.expected {
+			t.Fatalf("%v was drawn as %q instead of %q", test.values, line, test.expected)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// buildVersion identifies the bench build, set with `-ldflags "-X main.buildVersion=..."`.
+// Without it the VCS revision stamped by `go build` is used, if any.
+var buildVersion string
+
+// exitErrorBudget is the exit code of runs aborted for exceeding the error
+// budget, so CI scripts can tell them apart from completed runs.
+const exitErrorBudget = 3
+
+// minRateSamples is the number of exchanges to attempt before the
+// error rate is trusted enough to abort a run.
+const minRateSamples = 100
+
+// budgetExceeded reports whether the failed exchanges exceed the configured error budget.
+// Dropped connections don't count, as their requests are resent.
+func budgetExceeded(cfg *config, failures int, attempts int) bool {
+	if cfg.maxErrors > 0 && failures > cfg.maxErrors {
+		return true
+	}
+	return cfg.maxErrorRate > 0 && attempts >= minRateSamples &&
+		float64(failures)/float64(attempts) > float64(cfg.maxErrorRate)
+}
+
+// saturatedCPUShare is the fraction of wall time the client may spend on CPU
+// before we suspect the generator, rather than the server, is the bottleneck.
+const saturatedCPUShare = 0.9
+
+// saturatedGCShare is the fraction of wall time spent in GC pauses that
+// would noticeably distort the measured latencies.
+const saturatedGCShare = 0.01
+
+// blockedWriteThreshold is how long a write may take before we assume it waited
+// for socket buffer space, rather than just copying into the kernel.
+const blockedWriteThreshold = time.Millisecond
+
+// baselineDuration bounds the loopback baseline measured before the run.
+const baselineDuration = time.Second
+
+// generatorWarnings explains which indicators suggest the results were limited
+// by the load generator rather than the server.
+func generatorWarnings(utilization float64, gcPause time.Duration, elapsed time.Duration, blockedWrites int, transmits int) []string {
+	warnings := []string{}
+	if utilization >= saturatedCPUShare {
+		warnings = append(warnings, fmt.Sprintf("the client was busy %.0f%% of the time", utilization*100))
+	}
+	if share := gcPause.Seconds() / elapsed.Seconds(); share >= saturatedGCShare {
+		warnings = append(warnings, fmt.Sprintf("GC pauses took %.1f%% of the run", share*100))
+	}
+	if transmits > 0 && float64(blockedWrites)/float64(transmits) >= 0.01 {
+		warnings = append(warnings, fmt.Sprintf("%d writes blocked for over %s on socket buffers", blockedWrites, blockedWriteThreshold))
+	}
+	return warnings
+}
+
+// cpuTime returns the user and system CPU time consumed by this process so far.
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// checkLatencies guards the measurement code itself. With a single lockstep
+// connection exchanges never overlap, so no latency can be negative or exceed
+// the run, and together they can't take longer than the wall time.
+func checkLatencies(total, slowest, elapsed time.Duration, negative int) error {
+	switch {
+	case negative > 0:
+		return fmt.Errorf("%d exchanges measured negative latency", negative)
+	case slowest > elapsed:
+		return fmt.Errorf("the slowest exchange took %s, longer than the whole %s run", slowest, elapsed)
+	case total > elapsed:
+		return fmt.Errorf("exchanges add up to %s, more than the %s of wall time", total, elapsed)
+	}
+	return nil
+}
+
+func main() {
+	cfg := defineFlags(flag.CommandLine)
+	flag.Parse()
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	checkConfig(cfg, explicit)
+	if cfg.gcOff {
+		debug.SetGCPercent(-1)
+	}
+
+	switch {
+	case cfg.framing == "both":
+		runFraming(cfg)
+	case cfg.scenario == "head-of-line":
+		runHeadOfLine(cfg)
+	case cfg.scenario == "kv":
+		runKV(cfg)
+	default:
+		runLockstep(cfg)
+	}
+}
+
+// runFraming compares the framings of -framing both.
+func runFraming(cfg *config) {
+	if err := benchFraming(cfg); err != nil {
+		println("Comparing framings failed:", err.Error())
+		exit(1)
+	}
+	printLocalSources()
+}
+
+// runHeadOfLine runs -scenario head-of-line.
+func runHeadOfLine(cfg *config) {
+	if err := benchHeadOfLine(cfg); err != nil {
+		println("Head-of-line scenario failed:", err.Error())
+		exit(1)
+	}
+	printLocalSources()
+}
+
+// runKV runs -scenario kv, recording it in the -history like the main run.
+func runKV(cfg *config) {
+	result, elapsed := benchKV(cfg)
+	exchanges := printKV(cfg, result, elapsed)
+	printLocalSources()
+	if cfg.repro {
+		fmt.Printf("Reproduce with: %s\n", reproCommand())
+	}
+	if cfg.historyPath != "" {
+		recordHistory(cfg, exchanges, exchanges.count, float64(exchanges.count)/elapsed.Seconds(), result.invalid)
+	}
+}
+
+// lockstepRun accumulates the main run, from building its frames to the resources
+// it took.
+type lockstepRun struct {
+	frames        int
+	buildTime     time.Duration
+	baselineTimes phaseStats
+	sampledPID    int
+
+	start           time.Time
+	elapsed         time.Duration
+	restarts        int
+	transmits       int
+	drops           int
+	failures        int
+	negative        int
+	aborted         bool
+	total, slowest  time.Duration
+	requestSizes    sizeStats
+	replySizes      sizeStats
+	blockedWrites   int
+	buckets         map[int]*sizeBucket
+	exchangeTimes   latencyHistogram
+	writeTimes      latencyHistogram
+	waitTimes       latencyHistogram
+	overhead        wireOverhead
+	order           orderCheck
+	completeReplies int
+	partialReplies  int
+	nextSequence    int
+
+	cpu              time.Duration
+	startMem, endMem runtime.MemStats
+	serverSeries     []serverSample
+}
+
+// speed is the commands per second, every request of a -b batch counting.
+func (run *lockstepRun) speed(cfg *config) float64 {
+	return float64(run.transmits*max(cfg.batch, 1)) / run.elapsed.Seconds()
+}
+
+// snapshot is the checkpoint of the run so far, merged with the `resumed` one.
+func (run *lockstepRun) snapshot(resumed checkpoint) checkpoint {
+	current := checkpoint{
+		Saved: time.Now(), Elapsed: time.Since(run.start),
+		Transmits: run.transmits, Failures: run.failures, Drops: run.drops, Restarts: run.restarts,
+		Total: run.total, Latencies: run.exchangeTimes,
+	}
+	current.merge(resumed)
+	return current
+}
+
+// runLockstep makes the main run, one exchange at a time over a single connection,
+// unless -dry-run or -cold only sample the frames.
+func runLockstep(cfg *config) {
+	// Frames are built once up front, so serialization never lands inside the timed exchanges.
+	buildStart := time.Now()
+	frames, payloads, firstParams, err := buildFrames(cfg)
+	run := &lockstepRun{frames: len(frames), buildTime: time.Since(buildStart), buckets: map[int]*sizeBucket{}}
+	if err != nil {
+		println("Invalid schema:", err.Error())
+		exit(1)
+	}
+	if cfg.dryRun {
+		printDryRun(cfg, frames)
+		return
+	}
+	if cfg.cold > 0 {
+		benchCold(cfg, frames)
+		return
+	}
+	if cfg.baseline {
+		run.baselineTimes, err = benchBaseline(cfg, frames)
+		if err != nil {
+			println("Loopback baseline failed:", err.Error())
+			exit(1)
+		}
+	}
+
+	// The launched server's PID stays out of -server-pid, which -repro would print alongside -server-cmd
+	run.sampledPID = cfg.serverPID
+	if cfg.serverCmd != "" {
+		launched, err = startServer(cfg.serverCmd, cfg.servAddr)
+		if err != nil {
+			println("Launching the server failed:", err.Error())
+			exit(1)
+		}
+		run.sampledPID = launched.Process.Pid
+	}
+	// The client speaks 2.0 with origin-form targets, which forwarding proxies can't route
+	if cfg.jsonrpcVersion == "2.0" && !(cfg.html && cfg.proxyAddr != "") {
+		if err := checkServer(cfg, firstParams); err != nil {
+			fmt.Printf("Warning: the first call failed, the exchanges may all be errors: %v\n", err)
+		}
+	}
+	fmt.Printf("Validation: %s replies are %s\n", cfg.method, verifiability(cfg.method, cfg.verifyOrder))
+	if cfg.method == "validate_session" && !cfg.verifyOrder {
+		fmt.Println("Add -verify-order to check every validate_session result")
+	}
+
+	run.start = time.Now()
+	startCPU := cpuTime()
+	runtime.ReadMemStats(&run.startMem)
+	resumed := checkpoint{}
+	if cfg.resumePath != "" {
+		resumed, err = loadCheckpoint(cfg.resumePath)
+		if err != nil {
+			println("Resuming failed:", err.Error())
+			exit(1)
+		}
+	}
+	observers := startObservers(cfg, run, resumed)
+	run.exchange(cfg, frames, payloads, observers)
+	run.elapsed = time.Since(run.start)
+	run.serverSeries = observers.stop()
+	if launched != nil {
+		stopServer(launched)
+		launched = nil
+	}
+	run.cpu = cpuTime() - startCPU
+	runtime.ReadMemStats(&run.endMem)
+
+	printLockstep(cfg, run)
+	if cfg.repro {
+		fmt.Printf("Reproduce with: %s\n", reproCommand())
+	}
+	if cfg.compareURL != "" {
+		printComparison(cfg, run, benchGeneric(cfg, cfg.compareURL, []byte(firstParams)))
+	}
+	if cfg.checkpointPath != "" {
+		final := run.snapshot(resumed)
+		if err := saveCheckpoint(cfg.checkpointPath, final); err != nil {
+			println("Checkpointing failed:", err.Error())
+		}
+		if cfg.resumePath != "" {
+			fmt.Printf("\nCombined with the resumed run, after a %s gap since its last checkpoint:\n", run.start.Sub(resumed.Saved).Truncate(time.Second))
+			fmt.Printf("%d exchanges over %s, %.1f microseconds on average, %s\n",
+				final.Transmits, final.Elapsed.Truncate(time.Second), float64(final.Total.Microseconds())/float64(final.Transmits),
+				&final.Latencies)
+			fmt.Printf("%d connections dropped before replying, %d failed exchanges, %d reconnects\n", final.Drops, final.Failures, final.Restarts)
+		}
+	}
+	if cfg.historyPath != "" {
+		recordHistory(cfg, &run.exchangeTimes, run.transmits, run.speed(cfg), run.failures)
+	}
+	finishRun(run.aborted)
+}
+
+// runObservers follow the main run as it goes, each one only if its flag is set.
+type runObservers struct {
+	live               *liveStats
+	liveStop, liveDone chan struct{}
+
+	timeline     chan timelineRow
+	timelineDone chan error
+
+	// Checkpoints are written off the send loop, which only pays for the snapshot
+	checkpoints     chan checkpoint
+	checkpointsDone chan struct{}
+	lastCheckpoint  time.Time
+	resumed         checkpoint
+
+	sampledPID int
+	serverStop chan struct{}
+	serverDone chan []serverSample
+}
+
+// startObservers starts writing the checkpoints and the timeline, sampling the
+// server and rendering the live stats, as the flags ask.
+func startObservers(cfg *config, run *lockstepRun, resumed checkpoint) *runObservers {
+	observers := &runObservers{lastCheckpoint: run.start, resumed: resumed, sampledPID: run.sampledPID}
+	if cfg.checkpointPath != "" {
+		observers.checkpoints, observers.checkpointsDone = make(chan checkpoint, 1), make(chan struct{})
+		go func() {
+			for saved := range observers.checkpoints {
+				if err := saveCheckpoint(cfg.checkpointPath, saved); err != nil {
+					println("Checkpointing failed:", err.Error())
+				}
+			}
+			close(observers.checkpointsDone)
+		}()
+	}
+	if cfg.perConnCSV != "" {
+		observers.timeline, observers.timelineDone = make(chan timelineRow, 1024), make(chan error, 1)
+		go writeTimeline(cfg.perConnCSV, observers.timeline, observers.timelineDone)
+	}
+	if run.sampledPID != 0 {
+		observers.serverStop, observers.serverDone = make(chan struct{}), make(chan []serverSample, 1)
+		go sampleServer(run.sampledPID, run.start, cfg.interval, observers.serverStop, observers.serverDone)
+	}
+	if cfg.ui {
+		observers.live = &liveStats{}
+		observers.liveStop, observers.liveDone = make(chan struct{}), make(chan struct{})
+		go renderLive(cfg, observers.live, run.start, observers.liveStop, observers.liveDone)
+	}
+	return observers
+}
+
+// stop waits for the observers to finish, returning the samples of the server.
+func (observers *runObservers) stop() []serverSample {
+	if observers.live != nil {
+		close(observers.liveStop)
+		<-observers.liveDone
+	}
+	if observers.timeline != nil {
+		close(observers.timeline)
+		if err := <-observers.timelineDone; err != nil {
+			println("Writing the per-connection timeline failed:", err.Error())
+		}
+	}
+	if observers.checkpoints != nil {
+		close(observers.checkpoints)
+		<-observers.checkpointsDone
+	}
+	if observers.sampledPID == 0 {
+		return nil
+	}
+	close(observers.serverStop)
+	return <-observers.serverDone
+}
+
+// exchange sends the frames in a cycle, or numbered ones for -verify-order, redialing
+// whenever the connection breaks, until the limits or the error budget are reached.
+func (run *lockstepRun) exchange(cfg *config, frames [][]byte, payloads []int, observers *runObservers) {
+	throttled := cfg.readDelay > 0 || cfg.readRate > 0
+	// A server that accepts but never answers can't hold the run past -s
+	ctx, cancel := context.WithDeadline(context.Background(), run.start.Add(time.Duration(cfg.limitSeconds)*time.Second))
+	defer cancel()
+	for {
+		conn, err := dial(cfg)
+		if err != nil {
+			println("Dial failed:", err.Error())
+			exit(1)
+		}
+		c := newTimedClient(cfg, conn)
+		row := timelineRow{connection: run.restarts, start: time.Since(run.start)}
+
+		for {
+			frame, payload := frames[run.transmits%len(frames)], payloads[run.transmits%len(frames)]
+			first, count := 0, 0
+			if cfg.verifyOrder {
+				first = run.nextSequence
+				frame, payload, count = sequenceFrame(cfg, first)
+				run.nextSequence += count
+			}
+			reply, timing, err := c.exchange(ctx, frame)
+			if timing.written.Sub(timing.sent) > blockedWriteThreshold {
+				run.blockedWrites++
+			}
+			arrived := timing.replyBytes > 0
+			if !arrived && errors.Is(err, context.DeadlineExceeded) {
+				// The run ended during the exchange, which isn't counted, nor are its numbers
+				run.nextSequence -= count
+				break
+			}
+			// A connection closed before any reply bytes arrived isn't a failed
+			// exchange, but its numbered requests are never answered.
+			if !arrived {
+				if isDrop(err) {
+					run.drops++
+				} else {
+					run.failures++
+				}
+				row.errors++
+				if observers.live != nil {
+					observers.live.fail()
+				}
+				run.order.lose(first, count, run.restarts, timing.sent.Sub(run.start))
+				break
+			}
+			took := timing.received.Sub(timing.sent)
+			if run.transmits >= cfg.limitTransmits || time.Since(run.start).Seconds() >= float64(cfg.limitSeconds) {
+				// The exchange past the limits isn't counted, nor are its numbers
+				run.nextSequence -= count
+				break
+			}
+			run.transmits++
+			if took < 0 {
+				run.negative++
+			}
+			run.total += took
+			if took > run.slowest {
+				run.slowest = took
+			}
+			run.requestSizes.add(len(frame))
+			run.replySizes.add(timing.replyBytes)
+			run.overhead.requests++
+			run.overhead.requestWire += len(frame)
+			run.overhead.requestPayload += payload
+			if run.transmits%overheadSampling == 0 {
+				run.overhead.sample(reply, timing.replyBytes)
+			}
+			if throttled && err == nil {
+				run.completeReplies++
+			} else if throttled {
+				run.partialReplies++
+			}
+			if cfg.verifyOrder {
+				run.order.verify(reply, first, count, run.restarts, timing.sent.Sub(run.start))
+			}
+			if err != nil {
+				break
+			}
+			run.exchangeTimes.add(took)
+			run.writeTimes.add(timing.written.Sub(timing.sent))
+			run.waitTimes.add(timing.firstByte.Sub(timing.written))
+			bucket := run.buckets[bucketOf(timing.replyBytes)]
+			if bucket == nil {
+				bucket = &sizeBucket{}
+				run.buckets[bucketOf(timing.replyBytes)] = bucket
+			}
+			bucket.count++
+			bucket.total += took
+			if took > bucket.slowest {
+				bucket.slowest = took
+			}
+			if observers.live != nil {
+				observers.live.record(took)
+			}
+			if observers.timeline != nil {
+				row.add(took)
+				if now := time.Since(run.start); now-row.start >= cfg.interval {
+					observers.timeline <- row
+					row = timelineRow{connection: row.connection, start: now}
+				}
+			}
+			if observers.checkpoints != nil && time.Since(observers.lastCheckpoint) >= cfg.checkpointEvery {
+				observers.lastCheckpoint = time.Now()
+				// The writer may still be busy with the previous one, then this one is skipped
+				select {
+				case observers.checkpoints <- run.snapshot(observers.resumed):
+				default:
+				}
+			}
+		}
+		c.Close()
+		if observers.timeline != nil && (row.requests > 0 || row.errors > 0) {
+			observers.timeline <- row
+		}
+		if run.transmits >= cfg.limitTransmits || time.Since(run.start).Seconds() >= float64(cfg.limitSeconds) {
+			break
+		}
+		if budgetExceeded(cfg, run.failures, run.transmits+run.failures) {
+			run.aborted = true
+			break
+		}
+		run.restarts++
+	}
+}
+
+// printLockstep reports the main run.
+func printLockstep(cfg *config, run *lockstepRun) {
+	elapsed, transmits, failures := run.elapsed, run.transmits, run.failures
+	utilization := run.cpu.Seconds() / elapsed.Seconds()
+	if run.aborted {
+		fmt.Printf("Aborted after %d failed exchanges out of %d, exceeding the error budget\n", failures, transmits+failures)
+	}
+	latency := float64(elapsed.Microseconds()) / float64(transmits)
+	speed := run.speed(cfg)
+	if cfg.batch > 0 {
+		fmt.Printf("Took %s to perform %d queries with %d cmds per query\n", elapsed, transmits, cfg.batch)
+	} else {
+		fmt.Printf("Took %s to perform %d queries\n", elapsed, transmits)
+	}
+	fmt.Printf("Mean latency is %.1f microsecond\n", latency)
+	fmt.Printf("Resulting in %.1f commands/second\n", speed)
+	fmt.Printf("Exchanges took %.1f microseconds on average and %.1f at most, covering %.0f%% of wall time\n",
+		float64(run.total.Microseconds())/float64(transmits), float64(run.slowest.Microseconds()), run.total.Seconds()/elapsed.Seconds()*100)
+	if err := checkLatencies(run.total, run.slowest, elapsed, run.negative); err != nil {
+		fmt.Printf("Warning: latency measurements are inconsistent, %v\n", err)
+	}
+	// Large payloads spend a noticeable share of the exchange in the client's own
+	// kernel copies, the wait from the end of the write to the first reply bytes
+	// is what the server is responsible for.
+	fmt.Printf("Built %d distinct frames in %s before starting the clock\n", run.frames, run.buildTime)
+	fmt.Printf("Write time: %s\n", &run.writeTimes)
+	fmt.Printf("Wait time, to the first reply bytes: %s\n", &run.waitTimes)
+	if cfg.baseline {
+		fmt.Printf("Exchanges with the server: %s\n", &run.exchangeTimes)
+		fmt.Printf("Exchanges with an in-process loopback listener: %s\n", run.baselineTimes)
+	}
+	fmt.Printf("Request sizes: %s\n", &run.requestSizes)
+	fmt.Printf("Wire overhead: %s\n", &run.overhead)
+	if cfg.readDelay > 0 || cfg.readRate > 0 {
+		fmt.Printf("Throttled reads: %d replies drained completely, %d cut short by the connection closing\n",
+			run.completeReplies, run.partialReplies)
+		if cfg.readDelay > 0 {
+			fmt.Printf("Warning: wait times include the -read-delay before the first read of every reply\n")
+		}
+	}
+	commands := transmits * max(cfg.batch, 1)
+	validated := 0
+	if cfg.verifyOrder {
+		// Only requests answered and matched by id are covered, the wrong results included
+		validated = min(run.nextSequence-run.order.missing, commands)
+	}
+	fmt.Printf("Validation coverage: %d of %d commands checked, %.1f%%\n",
+		validated, commands, float64(validated)/float64(max(commands, 1))*100)
+	if validated == 0 {
+		fmt.Printf("Warning: replies weren't validated, wrong results and error replies count as successful exchanges\n")
+	}
+	if cfg.verifyOrder {
+		order := run.order
+		fmt.Printf("Order verification: %d requests numbered, %d never answered in %d gaps, %d wrong results, %d unexpected replies\n",
+			run.nextSequence, order.missing, len(order.gaps), order.wrong, order.unexpected)
+		for i, gap := range order.gaps {
+			if i == 10 {
+				fmt.Printf("- and %d more gaps\n", len(order.gaps)-i)
+				break
+			}
+			fmt.Printf("- connection %d lost requests %d to %d, sent %s into the run\n", gap.connection, gap.first, gap.last, gap.sent)
+		}
+	}
+	fmt.Printf("Reply sizes: %s\n", &run.replySizes)
+	bounds := make([]int, 0, len(run.buckets))
+	for bound := range run.buckets {
+		bounds = append(bounds, bound)
+	}
+	sort.Ints(bounds)
+	for _, bound := range bounds {
+		bucket := run.buckets[bound]
+		fmt.Printf("- replies up to %d bytes: %d exchanges, %.1f microseconds on average, %d at most\n",
+			bound, bucket.count, float64(bucket.total.Microseconds())/float64(bucket.count), bucket.slowest.Microseconds())
+	}
+	fmt.Printf("Recreating %d TCP connections\n", run.restarts)
+	printLocalSources()
+	fmt.Printf("Detected %d connections dropped before replying and %d failed exchanges\n", run.drops, failures)
+	fmt.Printf("Took %.1f wall seconds and %.1f CPU seconds (%.0f%% client CPU utilization)\n", elapsed.Seconds(), run.cpu.Seconds(), utilization*100)
+	startMem, endMem := &run.startMem, &run.endMem
+	fmt.Printf("Allocated %.1f objects/second, %.1f MB in total, %.1f MB of heap in use\n",
+		float64(endMem.Mallocs-startMem.Mallocs)/elapsed.Seconds(),
+		float64(endMem.TotalAlloc-startMem.TotalAlloc)/1e6, float64(endMem.HeapInuse)/1e6)
+	fmt.Printf("Ran %d GC cycles, pausing for %s in total\n",
+		endMem.NumGC-startMem.NumGC, time.Duration(endMem.PauseTotalNs-startMem.PauseTotalNs))
+	if run.sampledPID != 0 {
+		printServerSeries(run.sampledPID, cfg.interval, run.serverSeries, speed*elapsed.Seconds())
+	}
+	warnings := generatorWarnings(utilization, time.Duration(endMem.PauseTotalNs-startMem.PauseTotalNs), elapsed, run.blockedWrites, transmits)
+	for _, warning := range warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	if len(warnings) > 0 {
+		fmt.Printf("Warning: results are likely limited by the load generator, not the server\n")
+	}
+}
+
+// printComparison puts the main run next to the -compare-url one.
+func printComparison(cfg *config, run *lockstepRun, generic genericResult) {
+	transport := "JSON-RPC over raw TCP"
+	if cfg.html {
+		transport = "JSON-RPC over HTTP/1.1"
+	}
+	fmt.Printf("\nSame params, one connection at a time, no warm-up on either side:\n")
+	fmt.Printf("%-22s %26s %26s\n", "", "ucall", cfg.compareURL)
+	fmt.Printf("%-22s %26s %26s\n", "Transport", transport, "JSON over HTTP/1.1")
+	fmt.Printf("%-22s %26s %26s\n", "Client", "client.Client, raw frames", "net/http, keep-alive")
+	fmt.Printf("%-22s %26d %26d\n", "Requests", run.transmits, generic.transmits)
+	fmt.Printf("%-22s %26d %26d\n", "Failed exchanges", run.failures, generic.failures)
+	fmt.Printf("%-22s %26.1f %26.1f\n", "Mean latency, us",
+		float64(run.total.Microseconds())/float64(run.transmits), float64(generic.total.Microseconds())/float64(generic.transmits))
+	fmt.Printf("%-22s %26d %26d\n", "Max latency, us", run.slowest.Microseconds(), generic.slowest.Microseconds())
+	fmt.Printf("%-22s %26.1f %26.1f\n", "Requests/second",
+		float64(run.transmits)/run.elapsed.Seconds(), float64(generic.transmits)/generic.elapsed.Seconds())
+}
+
+// finishRun exits with exitErrorBudget if the run was aborted, while completed runs
+// just return from main.
+func finishRun(aborted bool) {
+	if aborted {
+		exit(exitErrorBudget)
+	}
+}
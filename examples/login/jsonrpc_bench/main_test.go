@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercent(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		expected percent
+	}{
+		{"1%", 0.01}, {"0.01", 0.01}, {"95%", 0.95}, {"0.95", 0.95}, {"0", 0}, {"0%", 0}, {"100%", 1}, {"1", 1},
+	} {
+		parsed := percent(0.5)
+		if err := parsed.Set(test.value); err != nil || parsed != test.expected {
+			t.Fatalf("%q was parsed as %v with %v", test.value, float64(parsed), err)
+		}
+	}
+	for _, value := range []string{"-1%", "-0.01", "101%", "1.5", "NaN", "one", "%", ""} {
+		parsed := percent(0.5)
+		if err := parsed.Set(value); err == nil || parsed != 0.5 {
+			t.Fatalf("%q was accepted as %v", value, float64(parsed))
+		}
+	}
+}
+
+func TestBudgetExceeded(t *testing.T) {
+	cfg := defineFlags(flag.NewFlagSet("bench", flag.ContinueOnError))
+	if budgetExceeded(cfg, 1000, 1000) {
+		t.Fatal("the budget was exceeded without any set")
+	}
+
+	cfg.maxErrorRate = 0.01
+	// Early failures don't abort before the rate can be trusted
+	if budgetExceeded(cfg, 10, minRateSamples-1) {
+		t.Fatalf("%d failures of %d attempts exceeded the budget", 10, minRateSamples-1)
+	}
+	if !budgetExceeded(cfg, 2, minRateSamples) {
+		t.Fatalf("%d failures of %d attempts stayed within 1%%", 2, minRateSamples)
+	}
+	if budgetExceeded(cfg, 1, minRateSamples) {
+		t.Fatalf("%d failure of %d attempts exceeded 1%%", 1, minRateSamples)
+	}
+
+	cfg.maxErrorRate, cfg.maxErrors = 0, 5
+	// The count applies from the first attempt
+	if budgetExceeded(cfg, 5, 5) || !budgetExceeded(cfg, 6, 6) {
+		t.Fatal("more than 5 failures are needed to exceed -max-errors 5")
+	}
+}
+
+// The test binary runs itself with BENCH_FINISH set to see the exit codes of runs.
+func TestFinishRun(t *testing.T) {
+	switch os.Getenv("BENCH_FINISH") {
+	case "aborted":
+		finishRun(true)
+		return
+	case "completed":
+		finishRun(false)
+		return
+	}
+	for _, test := range []struct {
+		finish string
+		code   int
+	}{{"aborted", exitErrorBudget}, {"completed", 0}} {
+		command := exec.Command(os.Args[0], "-test.run=^TestFinishRun$")
+		command.Env = append(os.Environ(), "BENCH_FINISH="+test.finish)
+		err := command.Run()
+		code := 0
+		exited := &exec.ExitError{}
+		if errors.As(err, &exited) {
+			code = exited.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Fatalf("the %s run exited with %d instead of %d", test.finish, code, test.code)
+		}
+	}
+}
+
+func TestCheckLatencies(t *testing.T) {
+	second := time.Second
+	for _, test := range []struct {
+		name                    string
+		total, slowest, elapsed time.Duration
+		negative                int
+		problem                 string
+	}{
+		{"consistent", second / 2, second / 10, second, 0, ""},
+		{"exactly the wall time", second, second, second, 0, ""},
+		{"negative latencies", second / 2, second / 10, second, 2, "2 exchanges measured negative latency"},
+		{"slowest above the run", second / 2, 2 * second, second, 0, "longer than the whole 1s run"},
+		{"total above the run", 3 * second, second / 10, second, 0, "exchanges add up to 3s, more than the 1s of wall time"},
+	} {
+		err := checkLatencies(test.total, test.slowest, test.elapsed, test.negative)
+		if test.problem == "" && err != nil || test.problem != "" && (err == nil || !strings.Contains(err.Error(), test.problem)) {
+			t.Fatalf("%s: got %v instead of %q", test.name, err, test.problem)
+		}
+	}
+}
+
+func TestGeneratorWarnings(t *testing.T) {
+	second := time.Second
+	if warnings := generatorWarnings(saturatedCPUShare-0.01, second/101, second, 9, 1000); len(warnings) != 0 {
+		t.Fatalf("a run below every threshold got warnings %q", warnings)
+	}
+	warnings := generatorWarnings(saturatedCPUShare, second/100, second, 10, 1000)
+	expected := []string{
+		"the client was busy 90% of the time",
+		"GC pauses took 1.0% of the run",
+		"10 writes blocked for over 1ms on socket buffers",
+	}
+	if strings.Join(warnings, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("a run at every threshold got warnings %q", warnings)
+	}
+	if warnings := generatorWarnings(0, 0, second, 0, 0); len(warnings) != 0 {
+		t.Fatalf("a run without exchanges got warnings %q", warnings)
+	}
+}
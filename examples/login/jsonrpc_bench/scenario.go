@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unum-cloud/ucall/ucall-go/client"
+)
+
+// headOfLineConnections run -scenario head-of-line concurrently, so a slow
+// exchange can hold back fast ones on other connections of the same server.
+const headOfLineConnections = 8
+
+// headOfLineSlowShare of the exchanges are slow in the second phase of the scenario.
+const headOfLineSlowShare = 0.01
+
+// headOfLineSlowBytes is the size of the slow echoes, copied by the server both ways.
+const headOfLineSlowBytes = 256 << 10
+
+// headOfLinePhase collects the exchanges of one phase of -scenario head-of-line.
+type headOfLinePhase struct {
+	sync.Mutex
+	fast     phaseStats
+	slow     phaseStats
+	failures int
+	pool     client.PoolStats
+}
+
+// benchHeadOfLine measures the configured traffic alone and then mixed with a share
+// of large echoes over the same pool of connections, for as long as -s lasts each.
+func benchHeadOfLine(cfg *config) error {
+	frames, _, _, err := buildFrames(cfg)
+	if err != nil {
+		return err
+	}
+	body := envelope(cfg, "echo", fmt.Sprintf(`{"data":"%s"}`, strings.Repeat("A", headOfLineSlowBytes)), 0)
+	slowFrame := []byte(body)
+	if cfg.html {
+		slowFrame = []byte(httpRequest(body, cfg.servAddr, cfg.target))
+	}
+
+	phases := [2]*headOfLinePhase{{}, {}}
+	for pass, share := range []float64{0, headOfLineSlowShare} {
+		phase := phases[pass]
+		pool := newPool(cfg, headOfLineConnections)
+		deadline := time.Now().Add(time.Duration(cfg.limitSeconds) * time.Second)
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		workers := sync.WaitGroup{}
+		for worker := 0; worker < headOfLineConnections; worker++ {
+			workers.Add(1)
+			go func(random *rand.Rand) {
+				defer workers.Done()
+				fast, slow, failures := phaseStats{}, phaseStats{}, 0
+				for time.Now().Before(deadline) {
+					isSlow := random.Float64() < share
+					frame := frames[random.Intn(len(frames))]
+					if isSlow {
+						frame = slowFrame
+					}
+					sent := time.Now()
+					if _, err := pool.RoundTrip(ctx, frame); err != nil {
+						if time.Now().Before(deadline) {
+							failures++
+							// Failures to connect wait a little before the next dial
+							if !isDrop(err) {
+								time.Sleep(10 * time.Millisecond)
+							}
+						}
+						continue
+					}
+					if isSlow {
+						slow = append(slow, time.Since(sent))
+					} else {
+						fast = append(fast, time.Since(sent))
+					}
+				}
+				phase.Lock()
+				phase.fast = append(phase.fast, fast...)
+				phase.slow = append(phase.slow, slow...)
+				phase.failures += failures
+				phase.Unlock()
+			}(rand.New(rand.NewSource(cfg.seed + int64(pass*headOfLineConnections+worker))))
+		}
+		workers.Wait()
+		cancel()
+		phase.pool = pool.Stats()
+		pool.Close()
+		sort.Slice(phase.fast, func(i, j int) bool { return phase.fast[i] < phase.fast[j] })
+		sort.Slice(phase.slow, func(i, j int) bool { return phase.slow[i] < phase.slow[j] })
+	}
+
+	alone, mixed := phases[0], phases[1]
+	fmt.Printf("Head-of-line blocking, %d connections, %s per phase, %.0f%% of %d KB echoes mixed into the second:\n",
+		headOfLineConnections, time.Duration(cfg.limitSeconds)*time.Second, headOfLineSlowShare*100, headOfLineSlowBytes>>10)
+	fmt.Printf("Fast exchanges alone: %d, %s\n", len(alone.fast), alone.fast)
+	fmt.Printf("Fast exchanges mixed: %d, %s\n", len(mixed.fast), mixed.fast)
+	fmt.Printf("Slow exchanges mixed: %d, %s\n", len(mixed.slow), mixed.slow)
+	fmt.Printf("Dropped connections: %d alone, %d mixed\n", alone.failures, mixed.failures)
+	fmt.Printf("Pool alone: %s\n", poolSummary(alone.pool))
+	fmt.Printf("Pool mixed: %s\n", poolSummary(mixed.pool))
+	fmt.Printf("Validation: replies are only read to their end, %s and echo ones are never checked\n", cfg.method)
+	if before := percentile(alone.fast, 0.99); before > 0 {
+		after := percentile(mixed.fast, 0.99)
+		fmt.Printf("Slow traffic moved the fast p99 from %s to %s, %+.1f%%\n",
+			before, after, float64(after-before)/float64(before)*100)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// fieldSchema describes how to generate one param, so users can load-test their own
+// methods with realistic variety. Schemas are JSON objects mapping names to fields:
+//
+//	{"user_id": {"type": "int", "min": 1, "max": 1000},
+//	 "bio": {"type": "string", "size": 80, "optional": 0.5},
+//	 "role": {"type": "enum", "values": ["admin", "guest"]},
+//	 "tags": {"type": "array", "size": 3, "items": {"type": "string", "size": 8}}}
+type fieldSchema struct {
+	// Type is one of "int", "float", "bool", "string", "enum", "array" or "object".
+	Type string `json:"type"`
+	// Min and Max bound numbers.
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	// Size is the length of strings and arrays.
+	Size int `json:"size"`
+	// Values lists the choices of enums, drawn with equal odds.
+	Values []any `json:"values"`
+	// Optional is the probability of the field being present, zero meaning always.
+	Optional float64 `json:"optional"`
+	// Items describes the elements of arrays.
+	Items *fieldSchema `json:"items"`
+	// Fields describes the members of objects.
+	Fields map[string]fieldSchema `json:"fields"`
+}
+
+func (field *fieldSchema) generate(random *rand.Rand) any {
+	switch field.Type {
+	case "int":
+		return int64(field.Min) + random.Int63n(int64(field.Max-field.Min)+1)
+	case "float":
+		return field.Min + random.Float64()*(field.Max-field.Min)
+	case "bool":
+		return random.Intn(2) == 1
+	case "string":
+		const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+		text := make([]byte, field.Size)
+		for i := range text {
+			text[i] = letters[random.Intn(len(letters))]
+		}
+		return string(text)
+	case "enum":
+		return field.Values[random.Intn(len(field.Values))]
+	case "array":
+		items := make([]any, field.Size)
+		for i := range items {
+			items[i] = field.Items.generate(random)
+		}
+		return items
+	case "object":
+		return generateParams(field.Fields, random)
+	}
+	return nil
+}
+
+// generateParams draws the fields in sorted order, so the same seed always yields the same params.
+func generateParams(fields map[string]fieldSchema, random *rand.Rand) map[string]any {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	params := make(map[string]any, len(fields))
+	for _, name := range names {
+		field := fields[name]
+		if field.Optional > 0 && random.Float64() >= field.Optional {
+			continue
+		}
+		params[name] = field.generate(random)
+	}
+	return params
+}
+
+// validateSchema rejects the shapes `generate` can't handle.
+func validateSchema(fields map[string]fieldSchema) error {
+	for name, field := range fields {
+		switch field.Type {
+		case "int", "float":
+			if field.Max < field.Min {
+				return fmt.Errorf("%q has max below min", name)
+			}
+		case "bool", "string":
+		case "enum":
+			if len(field.Values) == 0 {
+				return fmt.Errorf("%q is an enum without values", name)
+			}
+		case "array":
+			if field.Items == nil {
+				return fmt.Errorf("%q is an array without items", name)
+			}
+			if err := validateSchema(map[string]fieldSchema{name + "[]": *field.Items}); err != nil {
+				return err
+			}
+		case "object":
+			if err := validateSchema(field.Fields); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%q has unknown type %q", name, field.Type)
+		}
+	}
+	return nil
+}
+
+func loadSchema(path string) (map[string]fieldSchema, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]fieldSchema{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+	return fields, validateSchema(fields)
+}
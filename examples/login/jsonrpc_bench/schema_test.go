@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"user_id": {"type": "int", "min": 1, "max": 10},
+	"score": {"type": "float", "min": -0.5, "max": 0.5},
+	"role": {"type": "enum", "values": ["admin", "guest"]},
+	"bio": {"type": "string", "size": 12, "optional": 0.25},
+	"tags": {"type": "array", "size": 3, "items": {"type": "bool"}},
+	"address": {"type": "object", "fields": {"zip": {"type": "int", "min": 1000, "max": 1000}}}
+}`
+
+func loadTestSchema(t *testing.T, content string) (map[string]fieldSchema, error) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return loadSchema(path)
+}
+
+func TestGenerateParams(t *testing.T) {
+	fields, err := loadTestSchema(t, testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := generateParams(fields, rand.New(rand.NewSource(42)))
+	second := generateParams(fields, rand.New(rand.NewSource(42)))
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("the same seed gave %v and %v", first, second)
+	}
+
+	random := rand.New(rand.NewSource(7))
+	const draws = 10000
+	present := 0
+	for i := 0; i < draws; i++ {
+		params := generateParams(fields, random)
+		if id := params["user_id"].(int64); id < 1 || id > 10 {
+			t.Fatalf("user_id %d is out of its bounds", id)
+		}
+		if score := params["score"].(float64); score < -0.5 || score > 0.5 {
+			t.Fatalf("score %f is out of its bounds", score)
+		}
+		if role := params["role"]; role != "admin" && role != "guest" {
+			t.Fatalf("role %v isn't one of the values", role)
+		}
+		if tags := params["tags"].([]any); len(tags) != 3 {
+			t.Fatalf("tags %v don't have the size", tags)
+		}
+		if zip := params["address"].(map[string]any)["zip"]; zip != int64(1000) {
+			t.Fatalf("zip %v isn't the only value in bounds", zip)
+		}
+		if bio, ok := params["bio"]; ok {
+			present++
+			if len(bio.(string)) != 12 {
+				t.Fatalf("bio %q doesn't have the size", bio)
+			}
+		}
+	}
+	// Binomial with p = 0.25 has a deviation of about 43 over 10000 draws
+	if present < 2300 || present > 2700 {
+		t.Fatalf("the optional field was present %d times of %d, instead of about a quarter", present, draws)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	for _, test := range []struct {
+		name, schema, problem string
+	}{
+		{"unknown type", `{"when": {"type": "date"}}`, `unknown type "date"`},
+		{"min above max", `{"count": {"type": "int", "min": 5, "max": 4}}`, "max below min"},
+		{"empty enum", `{"role": {"type": "enum", "values": []}}`, "enum without values"},
+		{"enum without values", `{"role": {"type": "enum"}}`, "enum without values"},
+		{"array without items", `{"tags": {"type": "array", "size": 2}}`, "array without items"},
+		{"nested in an array", `{"tags": {"type": "array", "items": {"type": "float", "min": 1, "max": 0}}}`, "max below min"},
+		{"nested in an object", `{"user": {"type": "object", "fields": {"name": {"type": "text"}}}}`, `unknown type "text"`},
+	} {
+		_, err := loadTestSchema(t, test.schema)
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Fatalf("%s: got %v instead of an error about %s", test.name, err, test.problem)
+		}
+	}
+	if _, err := loadTestSchema(t, `{"user_id": {"type": "int"`); err == nil {
+		t.Fatal("a truncated schema was loaded")
+	}
+}
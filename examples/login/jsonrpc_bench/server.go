@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is the unit of CPU times in `/proc/<pid>/stat`, fixed for userspace.
+const clockTicks = 100
+
+// procRoot is where process entries are read from, replaced by tests with fixtures.
+var procRoot = "/proc"
+
+// serverSample is a reading of the server's `/proc` entries, with cumulative counters.
+type serverSample struct {
+	at       time.Duration
+	cpu      time.Duration
+	rss      int64
+	fds      int
+	switches int64
+}
+
+// readServerSample reads the CPU time, resident memory, open descriptors and
+// context switches of the process from `/proc`, so it only works on Linux.
+func readServerSample(pid int) (serverSample, error) {
+	sample := serverSample{}
+	stat, err := os.ReadFile(fmt.Sprintf("%s/%d/stat", procRoot, pid))
+	if err != nil {
+		return sample, err
+	}
+	// The command name may contain spaces, the fields are counted after its parenthesis
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	if len(fields) < 13 {
+		return sample, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	if fields[0] == "Z" {
+		return sample, fmt.Errorf("process %d has exited", pid)
+	}
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	sample.cpu = time.Duration(utime+stime) * time.Second / clockTicks
+
+	// Memory is shared by the process, but every thread counts its own context switches
+	threads, err := os.ReadDir(fmt.Sprintf("%s/%d/task", procRoot, pid))
+	if err != nil {
+		return sample, err
+	}
+	for _, thread := range threads {
+		status, err := os.ReadFile(fmt.Sprintf("%s/%d/task/%s/status", procRoot, pid, thread.Name()))
+		if err != nil {
+			// The thread has exited since listing
+			continue
+		}
+		for _, line := range strings.Split(string(status), "\n") {
+			name, value, _ := strings.Cut(line, ":")
+			number, _ := strconv.ParseInt(strings.Fields(value + " 0")[0], 10, 64)
+			switch name {
+			case "VmRSS":
+				sample.rss = number * 1024
+			case "voluntary_ctxt_switches", "nonvoluntary_ctxt_switches":
+				sample.switches += number
+			}
+		}
+	}
+
+	descriptors, err := os.ReadDir(fmt.Sprintf("%s/%d/fd", procRoot, pid))
+	if err != nil {
+		return sample, err
+	}
+	sample.fds = len(descriptors)
+	return sample, nil
+}
+
+// sampleServer reads the server's resources every interval until `stop` is closed,
+// sending the series, starting with a reading taken right away.
+func sampleServer(pid int, start time.Time, interval time.Duration, stop <-chan struct{}, done chan<- []serverSample) {
+	series := []serverSample{}
+	take := func() {
+		sample, err := readServerSample(pid)
+		if err != nil {
+			return
+		}
+		sample.at = time.Since(start)
+		series = append(series, sample)
+	}
+	take()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			take()
+			done <- series
+			return
+		case <-ticker.C:
+			take()
+		}
+	}
+}
+
+// startServer launches -server-cmd and waits for it to accept connections.
+func startServer(command string, servAddr string) (*exec.Cmd, error) {
+	// With `exec` the shell is replaced, so the sampled PID is the server itself
+	if conn, err := net.Dial("tcp", servAddr); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("something already listens on %s", servAddr)
+	}
+	server := exec.Command("sh", "-c", "exec "+command)
+	server.Stdout, server.Stderr = os.Stderr, os.Stderr
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", servAddr); err == nil {
+			conn.Close()
+			return server, nil
+		}
+	}
+	stopServer(server)
+	return nil, fmt.Errorf("nothing accepted connections on %s within 10s", servAddr)
+}
+
+// stopServer interrupts the server, killing it if it doesn't exit within 5 seconds.
+func stopServer(server *exec.Cmd) {
+	exited := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(exited)
+	}()
+	server.Process.Signal(os.Interrupt)
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		server.Process.Kill()
+		<-exited
+	}
+}
+
+// launched is the server started for -server-cmd, which exit stops first.
+var launched *exec.Cmd
+
+// exit stops the launched server before exiting, so no failure leaves it running.
+func exit(code int) {
+	if launched != nil {
+		stopServer(launched)
+	}
+	os.Exit(code)
+}
+
+// printServerSeries reports the server's resources per interval and over the whole run.
+func printServerSeries(pid int, interval time.Duration, series []serverSample, commands float64) {
+	if len(series) < 2 {
+		fmt.Printf("Server process %d couldn't be sampled, is it running on this Linux machine?\n", pid)
+		return
+	}
+	first, last := series[0], series[len(series)-1]
+	fmt.Printf("Server process %d, sampled every %s:\n", pid, interval)
+	peak, descriptors := int64(0), 0
+	for i, sample := range series {
+		peak = max(peak, sample.rss)
+		descriptors = max(descriptors, sample.fds)
+		if i == 0 {
+			continue
+		}
+		previous := series[i-1]
+		fmt.Printf("- at %s: %.0f%% CPU, %.1f MB RSS, %d descriptors, %d context switches\n",
+			sample.at.Truncate(time.Millisecond), (sample.cpu-previous.cpu).Seconds()/(sample.at-previous.at).Seconds()*100,
+			float64(sample.rss)/1e6, sample.fds, sample.switches-previous.switches)
+	}
+	busy := last.cpu - first.cpu
+	fmt.Printf("Server used %.1f CPU seconds, %.0f%% on average, %.1f MB RSS and %d descriptors at most, %d context switches\n",
+		busy.Seconds(), busy.Seconds()/(last.at-first.at).Seconds()*100, float64(peak)/1e6, descriptors, last.switches-first.switches)
+	if busy > 0 {
+		fmt.Printf("Resulting in %.1f commands per server core-second\n", commands/busy.Seconds())
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeProc lays out the /proc entries of the process 42 under a temporary root.
+func writeProc(t *testing.T, stat string, statuses []string, fds int) {
+	root := t.TempDir()
+	procRoot = root
+	t.Cleanup(func() { procRoot = "/proc" })
+	process := filepath.Join(root, "42")
+	for i, status := range statuses {
+		thread := filepath.Join(process, "task", strings.Repeat("1", i+1))
+		if err := os.MkdirAll(thread, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(thread, "status"), []byte(status), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(process, "fd"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < fds; i++ {
+		if err := os.WriteFile(filepath.Join(process, "fd", strings.Repeat("0", i+1)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(process, "stat"), []byte(stat), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadServerSample(t *testing.T) {
+	// The command name holds spaces and a parenthesis, utime and stime are 250 and 50 ticks
+	writeProc(t, "42 (ucall (main) x) S 1 42 42 0 -1 4194560 1000 0 0 0 250 50 0 0 20 0 3 0 100 1000000 2000\n",
+		[]string{
+			"Name:\tucall\nVmRSS:\t    2048 kB\nvoluntary_ctxt_switches:\t10\nnonvoluntary_ctxt_switches:\t5\n",
+			"Name:\tworker\nVmRSS:\t    2048 kB\nvoluntary_ctxt_switches:\t7\nnonvoluntary_ctxt_switches:\t1\n",
+		}, 4)
+	sample, err := readServerSample(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.cpu != 3*time.Second || sample.rss != 2048*1024 || sample.switches != 23 || sample.fds != 4 {
+		t.Fatalf("the process was read as %+v", sample)
+	}
+
+	writeProc(t, "42 (ucall) Z 1 42 42 0 -1 4194560 1000 0 0 0 250 50 0\n", nil, 0)
+	if _, err := readServerSample(42); err == nil || !strings.Contains(err.Error(), "has exited") {
+		t.Fatalf("a zombie was read with %v", err)
+	}
+	writeProc(t, "42 (ucall) S 1 42\n", nil, 0)
+	if _, err := readServerSample(42); err == nil || !strings.Contains(err.Error(), "unexpected") {
+		t.Fatalf("a truncated stat was read with %v", err)
+	}
+	writeProc(t, "", nil, 0)
+	if _, err := readServerSample(43); err == nil {
+		t.Fatal("a missing process was read")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// overheadSampling is how often replies are decoded to tell their results from
+// the envelope and headers around them.
+const overheadSampling = 64
+
+// sizeStats keeps a histogram of message sizes, bounded by the number of distinct sizes.
+type sizeStats struct {
+	counts map[int]int
+	total  int
+	count  int
+}
+
+func (sizes *sizeStats) add(size int) {
+	if sizes.counts == nil {
+		sizes.counts = map[int]int{}
+	}
+	sizes.counts[size]++
+	sizes.total += size
+	sizes.count++
+}
+
+func (sizes *sizeStats) String() string {
+	if sizes.count == 0 {
+		return "none"
+	}
+	keys := make([]int, 0, len(sizes.counts))
+	for size := range sizes.counts {
+		keys = append(keys, size)
+	}
+	sort.Ints(keys)
+	p99, seen := keys[len(keys)-1], 0
+	for _, size := range keys {
+		seen += sizes.counts[size]
+		if float64(seen) >= float64(sizes.count)*0.99 {
+			p99 = size
+			break
+		}
+	}
+	return fmt.Sprintf("min %d, mean %.1f, p99 %d, max %d bytes",
+		keys[0], float64(sizes.total)/float64(sizes.count), p99, keys[len(keys)-1])
+}
+
+// wireOverhead compares the bytes on the wire to the params and results they carry.
+// Requests are accounted in full, replies only every `overheadSampling` exchanges.
+type wireOverhead struct {
+	requests                    int
+	requestWire, requestPayload int
+	replyWire, replyPayload     int
+	replySamples, replySkipped  int
+}
+
+// sample accounts a reply by its body and the bytes it took on the wire, skipping
+// ones that can't be decoded, like those cut short by the connection closing.
+func (overhead *wireOverhead) sample(body []byte, wire int) {
+	single := map[string]json.RawMessage{}
+	many := []map[string]json.RawMessage{}
+	if json.Unmarshal(body, &single) == nil {
+		many = append(many, single)
+	} else if json.Unmarshal(body, &many) != nil {
+		overhead.replySkipped++
+		return
+	}
+	for _, member := range many {
+		overhead.replyPayload += len(member["result"]) + len(member["error"])
+	}
+	overhead.replyWire += wire
+	overhead.replySamples++
+}
+
+func (overhead *wireOverhead) String() string {
+	if overhead.requests == 0 {
+		return "none"
+	}
+	text := fmt.Sprintf("requests take %.1f wire bytes per %.1f bytes of params (%.2fx)",
+		float64(overhead.requestWire)/float64(overhead.requests), float64(overhead.requestPayload)/float64(overhead.requests),
+		float64(overhead.requestWire)/float64(overhead.requestPayload))
+	if overhead.replySamples == 0 {
+		return text + ", no replies could be decoded"
+	}
+	text += fmt.Sprintf(", replies %.1f wire bytes per %.1f bytes of results or errors (%.2fx, from %d samples)",
+		float64(overhead.replyWire)/float64(overhead.replySamples), float64(overhead.replyPayload)/float64(overhead.replySamples),
+		float64(overhead.replyWire)/float64(overhead.replyPayload), overhead.replySamples)
+	if overhead.replySkipped > 0 {
+		text += fmt.Sprintf(", skipping %d undecodable ones", overhead.replySkipped)
+	}
+	return text
+}
+
+// phaseStats collects the durations of one phase of every exchange.
+type phaseStats []time.Duration
+
+func (phase phaseStats) String() string {
+	if len(phase) == 0 {
+		return "none"
+	}
+	sorted := append(phaseStats(nil), phase...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return fmt.Sprintf("p50 %.1f, p99 %.1f, p99.9 %.1f, max %.1f microseconds",
+		float64(percentile(sorted, 0.5).Nanoseconds())/1e3, float64(percentile(sorted, 0.99).Nanoseconds())/1e3,
+		float64(percentile(sorted, 0.999).Nanoseconds())/1e3, float64(sorted[len(sorted)-1].Nanoseconds())/1e3)
+}
+
+// histogramSubBuckets split every power of two of nanoseconds, so the percentiles
+// taken from a latencyHistogram are within a 128th of the exact ones.
+const histogramSubBuckets = 64
+
+// latencyHistogram counts durations in buckets of the same relative width, so it
+// stays the same size however long the run, unlike a phaseStats. Durations under
+// two subbuckets of nanoseconds are counted exactly.
+type latencyHistogram struct {
+	counts  [58 * histogramSubBuckets]int
+	count   int
+	slowest time.Duration
+}
+
+// histogramBucket is the index of the bucket counting the duration.
+func histogramBucket(took time.Duration) int {
+	if took < 2*histogramSubBuckets {
+		return int(max(took, 0))
+	}
+	shift := bits.Len64(uint64(took)) - 7
+	return (shift+1)*histogramSubBuckets + int(took>>shift) - histogramSubBuckets
+}
+
+// histogramMidpoint is the middle of the durations the bucket counts.
+func histogramMidpoint(bucket int) time.Duration {
+	if bucket < 2*histogramSubBuckets {
+		return time.Duration(bucket)
+	}
+	shift := bucket/histogramSubBuckets - 1
+	lower := time.Duration(histogramSubBuckets+bucket%histogramSubBuckets) << shift
+	return lower + time.Duration(1)<<shift/2
+}
+
+func (histogram *latencyHistogram) add(took time.Duration) {
+	histogram.counts[histogramBucket(took)]++
+	histogram.count++
+	histogram.slowest = max(histogram.slowest, took)
+}
+
+// merge adds the durations counted by another histogram.
+func (histogram *latencyHistogram) merge(other *latencyHistogram) {
+	for bucket, count := range other.counts {
+		histogram.counts[bucket] += count
+	}
+	histogram.count += other.count
+	histogram.slowest = max(histogram.slowest, other.slowest)
+}
+
+// percentile approximates the duration the share of the counted ones doesn't exceed.
+func (histogram *latencyHistogram) percentile(share float64) time.Duration {
+	rank, seen := int(float64(histogram.count-1)*share), 0
+	for bucket, count := range histogram.counts {
+		seen += count
+		if seen > rank {
+			return min(histogramMidpoint(bucket), histogram.slowest)
+		}
+	}
+	return 0
+}
+
+func (histogram *latencyHistogram) String() string {
+	if histogram.count == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("p50 %.1f, p99 %.1f, p99.9 %.1f, max %.1f microseconds",
+		float64(histogram.percentile(0.5).Nanoseconds())/1e3, float64(histogram.percentile(0.99).Nanoseconds())/1e3,
+		float64(histogram.percentile(0.999).Nanoseconds())/1e3, float64(histogram.slowest.Nanoseconds())/1e3)
+}
+
+// sizeBucket groups latencies of replies within the same power of two of bytes.
+type sizeBucket struct {
+	count   int
+	total   time.Duration
+	slowest time.Duration
+}
+
+// bucketOf returns the upper bound of the power of two bucket the size falls into.
+func bucketOf(size int) int {
+	bound := 1
+	for bound < size {
+		bound *= 2
+	}
+	return bound
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Percentiles from the histogram stay within a 128th of the exact ones.
+func TestLatencyHistogram(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	histogram, exact := &latencyHistogram{}, phaseStats{}
+	for i := 0; i < 100_000; i++ {
+		took := time.Duration(random.ExpFloat64() * float64(50*time.Microsecond))
+		histogram.add(took)
+		exact = append(exact, took)
+	}
+	histogram.add(-time.Microsecond)
+	exact = append(exact, 0)
+	sort.Slice(exact, func(i, j int) bool { return exact[i] < exact[j] })
+	for _, share := range []float64{0, 0.5, 0.99, 0.999, 1} {
+		approximate, expected := histogram.percentile(share), percentile(exact, share)
+		if math.Abs(float64(approximate-expected)) > float64(expected)/128 {
+			t.Fatalf("the %g percentile is %s, instead of %s", share, approximate, expected)
+		}
+	}
+	if bucket := histogramBucket(math.MaxInt64); bucket != len(histogram.counts)-1 {
+		t.Fatalf("the longest duration falls into the bucket %d of %d", bucket, len(histogram.counts))
+	}
+	for _, took := range []time.Duration{0, 127, 128, time.Second, 1 << 62} {
+		if bucket := histogramBucket(took); histogramMidpoint(bucket) > took+took/128 || histogramMidpoint(bucket) < took-took/128 {
+			t.Fatalf("%s falls into the bucket %d, around %s", took, bucket, histogramMidpoint(bucket))
+		}
+	}
+	if (&latencyHistogram{}).String() != "none" {
+		t.Fatal("an empty histogram has percentiles")
+	}
+}
+
+func TestWireOverhead(t *testing.T) {
+	overhead := wireOverhead{requests: 2, requestWire: 200, requestPayload: 50}
+	if text := overhead.String(); text != "requests take 100.0 wire bytes per 25.0 bytes of params (4.00x), no replies could be decoded" {
+		t.Fatalf("requests alone were reported as %q", text)
+	}
+	overhead.sample([]byte(`{"jsonrpc":"2.0","id":1,"result":true}`), 60)
+	overhead.sample([]byte(`[{"id":2,"result":[1,2]},{"id":3,"error":{"code":-1}}]`), 100)
+	overhead.sample([]byte(`{"id":4,"res`), 12)
+	if overhead.replyWire != 160 || overhead.replyPayload != 20 || overhead.replySamples != 2 || overhead.replySkipped != 1 {
+		t.Fatalf("replies were accounted as %+v", overhead)
+	}
+	expected := "requests take 100.0 wire bytes per 25.0 bytes of params (4.00x), " +
+		"replies 80.0 wire bytes per 10.0 bytes of results or errors (8.00x, from 2 samples), skipping 1 undecodable ones"
+	if text := overhead.String(); text != expected {
+		t.Fatalf("the overhead was reported as %q", text)
+	}
+	if (&wireOverhead{}).String() != "none" {
+		t.Fatal("no requests have an overhead")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/unum-cloud/ucall/ucall-go/client"
+)
+
+// checkServer makes one regular call with the first params, as the timed exchanges
+// never parse replies and would happily measure error replies.
+func checkServer(cfg *config, params string) error {
+	conn, err := dial(cfg)
+	if err != nil {
+		return err
+	}
+	checker := client.New(conn)
+	checker.HTTP = cfg.html
+	defer checker.Close()
+	// A server that never answers shouldn't hang the bench before it even starts
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := checker.CallContext(ctx, cfg.method, json.RawMessage(params))
+	rpcErr := &client.Error{}
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.Code {
+		case client.MethodNotFound:
+			return fmt.Errorf("%w, the server has no -method %s", err, cfg.method)
+		case client.InvalidParams:
+			return fmt.Errorf("%w, compare -schema with the params %s expects", err, cfg.method)
+		case client.OutOfMemory:
+			return fmt.Errorf("%w, the server couldn't allocate the reply, try smaller params", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Checked the server answers %s, first result is %.60s\n", cfg.method, result)
+	return nil
+}
+
+// verifiability tells how much of the replies of the run the bench checks. Only
+// numbered validate_session requests have results it predicts, the replies to any
+// other method are timed without being parsed, after a first call checked for errors.
+func verifiability(method string, numbered bool) string {
+	if numbered && method == "validate_session" {
+		return "fully verified, every result is checked against the expected one"
+	}
+	return "unverified, only the first call is checked for an error reply"
+}
+
+// sequenceGap is a range of requests sent on one connection and never answered,
+// while the exchange carrying them got a reply.
+type sequenceGap struct {
+	connection  int
+	first, last int
+	sent        time.Duration
+}
+
+// orderCheck tracks which numbered requests were answered correctly.
+type orderCheck struct {
+	gaps       []sequenceGap
+	missing    int
+	wrong      int
+	unexpected int
+}
+
+// verify compares the reply body of an exchange with the `count` requests from `first` it carried.
+func (check *orderCheck) verify(reply []byte, first int, count int, connection int, sent time.Duration) {
+	type answer struct {
+		ID     *int  `json:"id"`
+		Result *bool `json:"result"`
+	}
+	single, many := answer{}, []answer{}
+	if json.Unmarshal(reply, &single) == nil {
+		many = append(many, single)
+	} else if json.Unmarshal(reply, &many) != nil {
+		many = nil
+	}
+	answered := make(map[int]bool, count)
+	for _, member := range many {
+		if member.ID == nil || *member.ID < first || *member.ID >= first+count || answered[*member.ID] {
+			check.unexpected++
+			continue
+		}
+		answered[*member.ID] = true
+		if member.Result == nil || *member.Result != (*member.ID%23 == 0) {
+			check.wrong++
+		}
+	}
+	for sequence := first; sequence < first+count; sequence++ {
+		if !answered[sequence] {
+			check.lose(sequence, 1, connection, sent)
+		}
+	}
+}
+
+// lose records requests that were never answered, like those of an exchange
+// dropped with its connection, extending the last gap if they follow it.
+func (check *orderCheck) lose(first int, count int, connection int, sent time.Duration) {
+	for sequence := first; sequence < first+count; sequence++ {
+		check.missing++
+		last := len(check.gaps) - 1
+		if last >= 0 && check.gaps[last].connection == connection && check.gaps[last].last == sequence-1 {
+			check.gaps[last].last = sequence
+		} else {
+			check.gaps = append(check.gaps, sequenceGap{connection: connection, first: sequence, last: sequence, sent: sent})
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Replies to the numbered requests 46 to 48 are checked against the ids sent and
+// their expected results, every request of 46 being `true` and the others `false`.
+func TestOrderCheckVerify(t *testing.T) {
+	for _, test := range []struct {
+		name                       string
+		reply                      string
+		missing, wrong, unexpected int
+		gaps                       []sequenceGap
+	}{
+		{"all answered", `[{"id":46,"result":true},{"id":48,"result":false},{"id":47,"result":false}]`, 0, 0, 0, nil},
+		{"missing id", `[{"id":46,"result":true},{"id":48,"result":false}]`, 1, 0, 0, []sequenceGap{{first: 47, last: 47}}},
+		{"duplicate id", `[{"id":46,"result":true},{"id":46,"result":true},{"id":47,"result":false},{"id":48,"result":false}]`, 0, 0, 1, nil},
+		{"out-of-range id", `[{"id":45,"result":false},{"id":46,"result":true},{"id":47,"result":false},{"id":49,"result":false}]`, 1, 0, 2, []sequenceGap{{first: 48, last: 48}}},
+		{"null id", `[{"id":null,"error":{"code":-32600}},{"id":46,"result":true},{"id":47,"result":false},{"id":48,"result":false}]`, 0, 0, 1, nil},
+		{"wrong result", `[{"id":46,"result":false},{"id":47,"result":true},{"id":48,"result":false}]`, 0, 2, 0, nil},
+		{"error reply", `[{"id":46,"error":{"code":-32601}},{"id":47,"result":false},{"id":48,"result":false}]`, 0, 1, 0, nil},
+		{"undecodable", `[{"id":46,"result":true},`, 3, 0, 0, []sequenceGap{{first: 46, last: 48}}},
+	} {
+		check := orderCheck{}
+		check.verify([]byte(test.reply), 46, 3, 0, 0)
+		if check.missing != test.missing || check.wrong != test.wrong || check.unexpected != test.unexpected ||
+			fmt.Sprint(check.gaps) != fmt.Sprint(test.gaps) {
+			t.Fatalf("%s: %d missing in %v, %d wrong, %d unexpected", test.name, check.missing, check.gaps, check.wrong, check.unexpected)
+		}
+	}
+
+	// A single request is answered with a lone object
+	check := orderCheck{}
+	check.verify([]byte(`{"id":23,"result":true}`), 23, 1, 0, 0)
+	check.verify([]byte(`{"id":24,"result":true}`), 24, 1, 0, 0)
+	if check.missing != 0 || check.wrong != 1 || check.unexpected != 0 {
+		t.Fatalf("single replies were checked as %+v", check)
+	}
+}
+
+// Requests of dropped exchanges are never answered, extending the gap they follow
+// on the same connection only.
+func TestOrderCheckLose(t *testing.T) {
+	check := orderCheck{}
+	check.lose(0, 3, 0, time.Second)
+	check.lose(3, 2, 0, 2*time.Second)
+	if check.missing != 5 || fmt.Sprint(check.gaps) != fmt.Sprint([]sequenceGap{{0, 0, 4, time.Second}}) {
+		t.Fatalf("consecutive drops on one connection made %d missing in %v", check.missing, check.gaps)
+	}
+	// Exchanges without any requests to number lose nothing
+	check.lose(0, 0, 1, 0)
+	if check.missing != 5 || len(check.gaps) != 1 {
+		t.Fatalf("an exchange of no requests lost %d in %v", check.missing, check.gaps)
+	}
+	// A dropped exchange that was answered in part is verified, losing the rest
+	check.verify([]byte(`[{"id":5,"result":false}]`), 5, 2, 0, 3*time.Second)
+	if check.missing != 6 || fmt.Sprint(check.gaps) != fmt.Sprint([]sequenceGap{{0, 0, 4, time.Second}, {0, 6, 6, 3 * time.Second}}) {
+		t.Fatalf("a partly answered exchange made %d missing in %v", check.missing, check.gaps)
+	}
+}
+
+// Connections keep gaps of their own, even for consecutive requests, while the counts
+// add up over all of them.
+func TestOrderCheckConnections(t *testing.T) {
+	check := orderCheck{}
+	check.lose(0, 2, 0, 0)
+	check.lose(2, 2, 1, 0)
+	check.verify([]byte(`[{"id":4,"result":false},{"id":4,"result":false}]`), 4, 2, 0, 0)
+	check.verify([]byte(`[{"id":6,"result":true},{"id":7,"result":false}]`), 6, 2, 1, 0)
+	expected := []sequenceGap{{0, 0, 1, 0}, {1, 2, 3, 0}, {0, 5, 5, 0}}
+	if fmt.Sprint(check.gaps) != fmt.Sprint(expected) || check.missing != 5 || check.unexpected != 1 || check.wrong != 1 {
+		t.Fatalf("connections 0 and 1 made %d missing in %v, %d unexpected, %d wrong", check.missing, check.gaps, check.unexpected, check.wrong)
+	}
+}
+
+// Methods are labeled by what the bench checks, not by what could be checked.
+func TestVerifiability(t *testing.T) {
+	for _, test := range []struct {
+		method   string
+		numbered bool
+		verified bool
+	}{
+		{"validate_session", true, true},
+		{"validate_session", false, false},
+		{"echo", false, false},
+		{"echo_text", false, false},
+		{"create_user", false, false},
+		{"unknown", false, false},
+	} {
+		if label := verifiability(test.method, test.numbered); strings.HasPrefix(label, "fully verified") != test.verified {
+			t.Fatalf("%s numbered %t is labeled %q", test.method, test.numbered, label)
+		}
+	}
+}
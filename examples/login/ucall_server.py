@@ -21,6 +21,20 @@ def echo(data: bytes):
     return data
 
 
+@server
+def echo_text(text: str):
+    return text
+
+
+@server
+def nested(depth: int, width: int):
+    # Deterministic on purpose, so the tests can rebuild the expected reply
+    node = list(range(width))
+    for level in range(depth):
+        node = {f'level{level}': node, 'width': width}
+    return node
+
+
 @server
 def create_user(age: int, name: str, avatar: bytes, bio: str):
     return f'Created {name} aged {age} with bio {bio} and avatar_size {len(avatar)}'
@@ -3,13 +3,34 @@
  */
 #include <cstdio> // `std::printf`
 #include <string>
+#include <string_view>
 #include <unordered_map>
 
 #include "ucall/ucall.h"
 
+/// Values are kept as JSON strings, so `get` can reply with them as they are.
 static std::unordered_map<std::string, std::string> store;
 
-static void set(ucall_call_t call) {
+static std::string quoted(std::string_view value) {
+    std::string result;
+    result.reserve(value.size() + 2);
+    result.push_back('"');
+    for (char c : value) {
+        if (static_cast<unsigned char>(c) < 0x20) {
+            char escaped[8];
+            std::snprintf(escaped, sizeof(escaped), "\\u%04x", c);
+            result += escaped;
+            continue;
+        }
+        if (c == '"' || c == '\\')
+            result.push_back('\\');
+        result.push_back(c);
+    }
+    result.push_back('"');
+    return result;
+}
+
+static void set(ucall_call_t call, ucall_callback_tag_t) {
     char const* key_ptr{};
     char const* value_ptr{};
     size_t key_len{};
@@ -19,22 +40,22 @@ static void set(ucall_call_t call) {
     if (!key_found || !value_found)
         return ucall_call_reply_error_invalid_params(call);
 
-    store.insert_or_assign(std::string_view{key_ptr, key_len}, std::string_view{value_ptr, value_len});
-    return ucall_call_reply_content(call, "OK", 2);
+    store.insert_or_assign(std::string{key_ptr, key_len}, quoted({value_ptr, value_len}));
+    return ucall_call_reply_content(call, "\"OK\"", 4);
 }
 
-static void get(ucall_call_t call) {
+static void get(ucall_call_t call, ucall_callback_tag_t) {
     char const* key_ptr{};
     size_t key_len{};
-    bool key_found = ucall_param_named_str(call, "key", 4, &key_ptr, &key_len);
+    bool key_found = ucall_param_named_str(call, "key", 3, &key_ptr, &key_len);
     if (!key_found)
         return ucall_call_reply_error_invalid_params(call);
 
-    auto iterator = store.find(std::string_view{key_ptr, key_len});
+    auto iterator = store.find(std::string{key_ptr, key_len});
     if (iterator == store.end())
-        return ucall_call_reply_content(call, "", 0);
+        return ucall_call_reply_content(call, "null", 4);
     else
-        return ucall_call_reply_content(call, iterator.second.c_str(), iterator.second.size());
+        return ucall_call_reply_content(call, iterator->second.c_str(), iterator->second.size());
 }
 
 int main(int argc, char** argv) {
@@ -48,8 +69,8 @@ int main(int argc, char** argv) {
     }
 
     std::printf("Initialized server!\n");
-    ucall_add_procedure(server, "set", &set);
-    ucall_add_procedure(server, "get", &get);
+    ucall_add_procedure(server, "set", &set, nullptr);
+    ucall_add_procedure(server, "get", &get, nullptr);
 
     ucall_take_calls(server, 0);
     ucall_free(server);
@@ -1,10 +1,24 @@
+import os
+import re
+import sys
+import gzip
+import json
+import time
+import base64
 import random
+import codecs
+import socket
+import struct
+import statistics
+from concurrent.futures import ThreadPoolExecutor
 
+import pytest
 import requests
 import numpy as np
 from PIL import Image
 from ucall.client import Client, ClientTLS
 from login.jsonrpc_client import CaseHTTP, CaseHTTPBatches, CaseTCP
+from login.jsonrpc_client import REQUEST_PATTERN, HTTP_HEADERS, make_tcp_socket, socket_is_closed
 
 
 class ClientGeneric:
@@ -14,7 +28,127 @@ class ClientGeneric:
         self.url = f'http://{uri}:{port}/'
 
     def __call__(self, jsonrpc: object) -> object:
-        return requests.post(self.url, json=jsonrpc).json()
+        return strict_json(requests.post(self.url, json=jsonrpc).content)
+
+
+def strict_json(content: bytes) -> object:
+    """Parses a reply, failing on invalid UTF-8 and on control characters left unescaped in strings"""
+    try:
+        text = content.decode('utf-8')
+    except UnicodeDecodeError as e:
+        pytest.fail(f'Reply is not valid UTF-8, {e}: {content[:200]!r}')
+    return json.loads(text, strict=True)
+
+
+# Methods registered by each example server, so tests needing others are skipped rather than failed.
+# Pick one with `UCALL_PROFILE`, or point it at a JSON file like `{"methods": ["validate_session"]}`
+# describing your own server. Without a profile every test runs.
+PROFILES = {
+    'login': ['validate_session', 'echo', 'echo_text', 'nested', 'create_user', 'transform'],
+    'login_rich': ['validate_session', 'create_user', 'rotate_avatar', 'validate_all_sessions'],
+    'login_cpp': ['validate_session'],
+    'redis': ['set', 'get'],
+    'pytorch': ['summarize', 'continue'],
+}
+
+
+def load_profile(name: str) -> set:
+    if not name:
+        return None
+    if name in PROFILES:
+        return set(PROFILES[name])
+    with open(name) as file:
+        return set(json.load(file)['methods'])
+
+
+PROFILE = os.environ.get('UCALL_PROFILE', '')
+PROFILE_METHODS = load_profile(PROFILE)
+
+
+def requires(*methods: str):
+    missing = [] if PROFILE_METHODS is None else sorted(set(methods) - PROFILE_METHODS)
+    return pytest.mark.skipif(bool(missing), reason=f'The {PROFILE} profile lacks {", ".join(missing)}')
+
+
+class JSONStream:
+    """Splits a raw TCP stream into consecutive top-level JSON values, keeping the history for dumps"""
+
+    def __init__(self, sock: socket.socket, timeout: float = 5.0) -> None:
+        self.sock = sock
+        self.sock.settimeout(timeout)
+        self.decoder = codecs.getincrementaldecoder('utf-8')()
+        self.text = ''
+        self.offset = 0
+
+    def next(self) -> object:
+        """Returns the next JSON value, or `None` if the connection was closed before it arrived"""
+        while True:
+            while self.offset < len(self.text) and self.text[self.offset].isspace():
+                self.offset += 1
+            try:
+                value, end = json.JSONDecoder().raw_decode(self.text, self.offset)
+                self.offset = end
+                return value
+            except json.JSONDecodeError:
+                chunk = self.sock.recv(65536)
+                if not chunk:
+                    return None
+                self.text += self.decoder.decode(chunk)
+
+    def neighborhood(self, radius: int = 256) -> str:
+        return self.text[max(0, self.offset - radius):self.offset + radius]
+
+
+def http_exchange(body: bytes, headers: str = HTTP_HEADERS) -> tuple:
+    """Sends one HTTP request over a fresh raw socket, returning the reply head and body as received"""
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    sock.sendall((headers % len(body)).encode() + body)
+    reply = read_http_reply(sock)
+    sock.close()
+    return reply
+
+
+def read_http_reply(sock: socket.socket) -> tuple:
+    sock.settimeout(5)
+    reply = b''
+    while b'\r\n\r\n' not in reply:
+        chunk = sock.recv(4096)
+        assert chunk, f'Connection closed mid-headers: {reply!r}'
+        reply += chunk
+    head, content = reply.split(b'\r\n\r\n', 1)
+    length = re.search(rb'Content-Length:\s*(\d+)', head, re.IGNORECASE)
+    while length and len(content) < int(length.group(1)):
+        chunk = sock.recv(4096)
+        assert chunk, f'Connection closed mid-body: {content!r}'
+        content += chunk
+    return head, content
+
+
+def raw_id(content: bytes) -> bytes:
+    """Extracts the `id` member exactly as the server printed it"""
+    found = re.search(rb'"id":\s*("(?:[^"\\]|\\.)*"|[^,}\s]+)', content)
+    return found.group(1) if found else None
+
+
+def request_with_sequence(sequence: int) -> bytes:
+    # With zero `session_id` the expected result depends on the sequence number alone
+    return (REQUEST_PATTERN % (sequence, sequence, 0)).encode()
+
+
+def skip_unless_keep_alive(sock: socket.socket) -> None:
+    sock.send(request_with_sequence(0))
+    assert JSONStream(sock).next() is not None
+    sock.settimeout(None)
+    time.sleep(0.05)
+    if socket_is_closed(sock):
+        pytest.skip('The server closes the connection after every reply')
+
+
+def assert_sequence_reply(stream: JSONStream, response: object, sequence: int) -> None:
+    context = f'around sequence {sequence}: {stream.neighborhood()!r}'
+    assert response is not None, f'Connection closed {context}'
+    assert response.get('id', None) == sequence, f'Out of order reply {context}'
+    assert response.get('result', None) == (sequence % 23 == 0), f'Wrong answer {context}'
 
 
 def shuffled_n_identities(class_, count_clients: int = 3, count_cycles: int = 1000):
@@ -33,22 +167,26 @@ def shuffled_n_identities(class_, count_clients: int = 3, count_cycles: int = 10
             client.recv()
 
 
+@requires('validate_session')
 def test_shuffled_tcp():
     for connections in range(1, 10):
         shuffled_n_identities(CaseTCP, count_clients=connections)
 
 
+@requires('validate_session')
 def test_shuffled_http():
     for connections in range(1, 10):
         shuffled_n_identities(CaseHTTP, count_clients=connections)
 
 
+@requires('validate_session')
 def test_shuffled_http_batches():
     for connections in range(1, 10):
         print(connections)
         shuffled_n_identities(CaseHTTPBatches, count_clients=connections)
 
 
+@requires('validate_session')
 def test_uniform_batches():
     client = CaseHTTPBatches()
     for batch_size in range(1, 100):
@@ -57,6 +195,915 @@ def test_uniform_batches():
         client.recv()
 
 
+@requires('validate_session')
+def test_ordering_sequential(count: int = 1000):
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    skip_unless_keep_alive(sock)
+    stream = JSONStream(sock)
+    for sequence in range(1, count + 1):
+        sock.send(request_with_sequence(sequence))
+        assert_sequence_reply(stream, stream.next(), sequence)
+    sock.close()
+
+
+@requires('validate_session')
+def test_ordering_pipelined(count: int = 1000):
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    skip_unless_keep_alive(sock)
+    stream = JSONStream(sock)
+    sock.sendall(b''.join(request_with_sequence(sequence)
+                 for sequence in range(1, count + 1)))
+    for sequence in range(1, count + 1):
+        assert_sequence_reply(stream, stream.next(), sequence)
+    sock.close()
+
+
+@requires('validate_session')
+@pytest.mark.parametrize('framing', ['raw', 'http'])
+def test_batch_pipelined_between_singles(framing: str):
+    # Aggressive clients mix batches into their pipelines, each top-level value keeps its place
+    batch = b'[' + b','.join(request_with_sequence(sequence) for sequence in range(100, 110)) + b']'
+    bodies = [request_with_sequence(23), batch, request_with_sequence(46)]
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    skip_unless_keep_alive(sock)
+    if framing == 'http':
+        sock.sendall(b''.join((HTTP_HEADERS % len(body)).encode() + body for body in bodies))
+        replies = read_http_replies(sock)
+        context = f'{replies!r:.400}'
+    else:
+        sock.sendall(b''.join(bodies))
+        stream = JSONStream(sock)
+        replies = [stream.next() for _ in bodies]
+        context = repr(stream.neighborhood())
+    assert len(replies) == 3, f'Expected an object, an array and an object: {context}'
+    first, many, last = replies
+    assert first == {'jsonrpc': '2.0', 'id': 23, 'result': True}, f'Wrong first reply: {context}'
+    assert isinstance(many, list) and len(many) == 10, f'Wrong batch reply: {context}'
+    assert sorted(reply.get('id') for reply in many) == list(range(100, 110)), f'Wrong batch IDs: {context}'
+    for reply in many:
+        assert reply.get('result') == (reply['id'] % 23 == 0), f'Wrong answer in the batch: {context}'
+    assert last == {'jsonrpc': '2.0', 'id': 46, 'result': True}, f'Wrong last reply: {context}'
+    sock.close()
+
+
+def nested_object(depth: int, width: int) -> object:
+    node = list(range(width))
+    for level in range(depth):
+        node = {f'level{level}': node, 'width': width}
+    return node
+
+
+def post_or_fail(client: ClientGeneric, jsonrpc: object, what: str) -> object:
+    try:
+        return client(jsonrpc)
+    except requests.ConnectionError as e:
+        pytest.fail(f'Connection closed without a reply for {what}: {e}')
+
+
+def assert_result_or_clean_error(response: object, expected: object) -> None:
+    if 'error' in response:
+        assert isinstance(response['error']['code'], int)
+        assert isinstance(response['error']['message'], str)
+    else:
+        assert response['result'] == expected
+
+
+@requires('nested')
+def test_nested_reply():
+    client = ClientGeneric()
+    for depth, width in [(1, 10), (10, 10), (100, 1), (1, 100_000), (1, 1_500_000)]:
+        response = post_or_fail(client, {
+            'method': 'nested',
+            'params': {'depth': depth, 'width': width},
+            'jsonrpc': '2.0',
+            'id': 0,
+        }, f'depth {depth} and width {width}')
+        assert_result_or_clean_error(response, nested_object(depth, width))
+
+
+@requires('echo')
+def test_echo_reply_sizes():
+    # Walks up to ~10 MB, so the size at which replies turn into errors shows in the log.
+    client = ClientGeneric()
+    for size in [2**power for power in range(10, 24)] + [10_000_000]:
+        data = base64.b64encode(random.randbytes(size * 3 // 4)).decode()
+        response = post_or_fail(client, {
+            'method': 'echo',
+            'params': {'data': data},
+            'jsonrpc': '2.0',
+            'id': 0,
+        }, f'{len(data)} bytes')
+        if 'error' in response:
+            print(f'Echo of {len(data)} bytes fails with: {response["error"]}')
+        assert_result_or_clean_error(response, data)
+
+
+# How long a half-open request may stay unresolved on the server before the test fails
+HALF_OPEN_TIMEOUT_SECONDS = 30
+
+
+def assert_half_open_resolves(body_fraction: float):
+    body = (REQUEST_PATTERN % (0, 2, 2)).encode()
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    sock.send((HTTP_HEADERS % len(body)).encode() +
+              body[:int(len(body) * body_fraction)])
+    started = time.monotonic()
+
+    # Meanwhile, other connections must be served as usual
+    for _ in range(100):
+        response = requests.post('http://127.0.0.1:8545/', timeout=1, json={
+            'method': 'validate_session',
+            'params': {'user_id': 2, 'session_id': 2},
+            'jsonrpc': '2.0',
+            'id': 0,
+        })
+        assert response.json()['result'] == True
+
+    sock.settimeout(HALF_OPEN_TIMEOUT_SECONDS)
+    try:
+        reply = sock.recv(4096)
+    except socket.timeout:
+        pytest.fail(
+            f'Half-open request is still pending after {HALF_OPEN_TIMEOUT_SECONDS} seconds')
+    finally:
+        sock.close()
+
+    print(f'Half-open request resolved after {time.monotonic() - started:.1f} seconds')
+    assert len(reply) == 0 or b'"error"' in reply, reply
+
+
+@requires('validate_session')
+def test_body_never_arrives():
+    assert_half_open_resolves(0)
+
+
+@requires('validate_session')
+def test_body_half_arrives():
+    assert_half_open_resolves(0.5)
+
+
+def request_with_raw_id(id: str) -> bytes:
+    return ('{"jsonrpc":"2.0","method":"validate_session","params":{"user_id":2,"session_id":2},"id":%s}' % id).encode()
+
+
+@requires('validate_session')
+def test_id_integer_verbatim():
+    for id in ['1', '0', '-7', str(2**40)]:
+        _, content = http_exchange(request_with_raw_id(id))
+        assert raw_id(content) == id.encode(), content
+
+
+@requires('validate_session')
+def test_id_non_integer_numbers():
+    # Fractional and exponent forms are either rejected or echoed as an equal number.
+    for id in ['1.0', '1e0']:
+        _, content = http_exchange(request_with_raw_id(id))
+        response = json.loads(content)
+        if 'error' in response:
+            assert response['error']['code'] == -32600, content
+        else:
+            assert json.loads(raw_id(content)) == 1, content
+
+
+@requires('validate_session')
+def test_id_string_verbatim():
+    for id in ['"1"', '"abc"']:
+        _, content = http_exchange(request_with_raw_id(id))
+        assert raw_id(content) == id.encode(), content
+
+
+@requires('validate_session')
+def test_id_string_length_limit():
+    # Echoed string IDs may take up to 254 bytes escaped, 256 with their quotes
+    for id in ['a' * 254, '\\"' * 127, '\\u0001' * 42 + 'aa']:
+        _, content = http_exchange(request_with_raw_id(f'"{id}"'))
+        assert raw_id(content) == f'"{id}"'.encode(), content
+    for id in ['a' * 255, '\\"' * 128, '\\u0001' * 42 + 'aaa']:
+        _, content = http_exchange(request_with_raw_id(f'"{id}"'))
+        response = strict_json(content)
+        assert response['id'] is None and response['error']['code'] == -32600, content
+
+
+def tagged_echo_batch(tags: list, ids: list) -> list:
+    return [{
+        'method': 'echo',
+        'params': {'data': base64.b64encode(tag.encode()).decode()},
+        'jsonrpc': '2.0',
+        'id': id,
+    } for tag, id in zip(tags, ids)]
+
+
+def assert_tagged_echo_replies(batch: list, response: list) -> None:
+    assert len(response) == len(batch), response
+    for request, reply in zip(batch, response):
+        assert reply['id'] == request['id'], reply
+        assert reply['result'] == request['params']['data'], 'Cross-contaminated reply'
+
+
+def overlapping_ids_on_connection(connection: int, count_batches: int = 100) -> None:
+    session = requests.Session()
+    ids = list(range(16))
+    for cycle in range(count_batches):
+        batch = tagged_echo_batch(
+            [f'{connection}:{cycle}:{id}' for id in ids], ids)
+        response = session.post('http://127.0.0.1:8545/', json=batch).json()
+        assert_tagged_echo_replies(batch, response)
+
+
+@requires('echo')
+def test_overlapping_ids_across_connections():
+    with ThreadPoolExecutor(64) as executor:
+        for result in [executor.submit(overlapping_ids_on_connection, connection) for connection in range(64)]:
+            result.result()
+
+
+@requires('echo')
+def test_duplicate_ids_within_batch():
+    # Every entry gets its own reply in request order, even if the ids repeat
+    client = ClientGeneric()
+    ids = [0, 0, 1, 1, 0, 7, 7, 7]
+    batch = tagged_echo_batch([f'duplicate:{i}' for i in range(len(ids))], ids)
+    assert_tagged_echo_replies(batch, client(batch))
+
+
+# Median gap between the last request byte written and the first reply byte arriving
+FIRST_BYTE_MEDIAN_BOUND_SECONDS = float(
+    os.environ.get('UCALL_FIRST_BYTE_MEDIAN_BOUND_US', 500)) / 1e6
+
+
+@requires('validate_session')
+def test_first_byte_delay(count_samples: int = 500):
+    # A median needs few samples, and every one takes a connection, so keep them few
+    request = request_with_sequence(1)
+    gaps = []
+    for _ in range(count_samples):
+        sock = make_tcp_socket('127.0.0.1', 8545)
+        sock.setsockopt(socket.IPPROTO_TCP, socket.TCP_NODELAY, 1)
+        sock.sendall(request)
+        written = time.perf_counter()
+        assert sock.recv(4096), 'Connection closed without a reply'
+        gaps.append(time.perf_counter() - written)
+        sock.close()
+
+    median = statistics.median(gaps)
+    assert median <= FIRST_BYTE_MEDIAN_BOUND_SECONDS, \
+        f'Median first byte delay of {median * 1e6:.0f} us exceeds {FIRST_BYTE_MEDIAN_BOUND_SECONDS * 1e6:.0f} us'
+
+
+@requires('validate_session')
+def test_http_split_writes_then_reuse():
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    body = request_with_sequence(1)
+    sock.send((HTTP_HEADERS % len(body)).encode())
+    time.sleep(0.1)
+    sock.send(body[:len(body) // 2])
+    time.sleep(0.1)
+    sock.send(body[len(body) // 2:])
+    _, content = read_http_reply(sock)
+    assert json.loads(content)['id'] == 1, content
+
+    sock.settimeout(None)
+    time.sleep(0.05)
+    if socket_is_closed(sock):
+        pytest.skip('The server closes the connection after every reply')
+
+    body = request_with_sequence(2)
+    sock.send((HTTP_HEADERS % len(body)).encode() + body)
+    _, content = read_http_reply(sock)
+    assert json.loads(content)['id'] == 2, content
+
+    # Switching to raw framing on the same connection is either served or refused by closing
+    sock.send(request_with_sequence(3))
+    response = JSONStream(sock).next()
+    if response is None:
+        print('Raw frames after HTTP ones on the same connection are not supported')
+    else:
+        assert response['id'] == 3, response
+    sock.close()
+
+
+@requires('validate_session')
+def test_unknown_extra_params():
+    session = requests.Session()
+    extras = {
+        'string': 'text',
+        'number': 1.5,
+        'object': {'nested': [1, {'deeper': None}]},
+        'array': [1, 'two', None, False],
+        'bool': True,
+        'null': None,
+        'padding': 'A' * 100_000,
+    }
+    for user_id, session_id in [(2, 2), (1, 2)]:
+        params = {'user_id': user_id, 'session_id': session_id}
+        plain = {'method': 'validate_session',
+                 'params': params, 'jsonrpc': '2.0', 'id': 0}
+        expected = session.post('http://127.0.0.1:8545/', json=plain).json()
+        assert 'result' in expected, expected
+
+        for variant in [{name: value} for name, value in extras.items()] + [extras]:
+            response = session.post('http://127.0.0.1:8545/', json={
+                **plain, 'params': {**params, **variant}}).json()
+            assert response == expected, f'Extra params {list(variant)} changed the reply'
+            # The big extra field must not leave the connection in a bad state
+            assert session.post('http://127.0.0.1:8545/', json=plain).json() == expected
+
+
+def read_http_replies(sock: socket.socket) -> list:
+    """Collects reply bodies until the server closes the connection or goes quiet"""
+    sock.settimeout(1)
+    replies, received = [], b''
+    while True:
+        try:
+            chunk = sock.recv(4096)
+        except (TimeoutError, socket.timeout, ConnectionResetError):
+            break
+        if not chunk:
+            break
+        received += chunk
+        while b'\r\n\r\n' in received:
+            head, rest = received.split(b'\r\n\r\n', 1)
+            length = re.search(rb'Content-Length:\s*(\d+)', head, re.IGNORECASE)
+            if length is None or len(rest) < int(length.group(1)):
+                break
+            replies.append(json.loads(rest[:int(length.group(1))]))
+            received = rest[int(length.group(1)):]
+    return replies
+
+
+@requires('validate_session')
+@pytest.mark.parametrize('garbage', [
+    random.Random(0).randbytes(100),
+    b'POST / HTTP/1.1\r\nHost: 127.0.0.1:8545\r\nContent-Type: application/json\r\nContent-Le',
+], ids=['binary', 'truncated_headers'])
+def test_garbage_after_content_length(garbage: bytes):
+    # Both sequences are multiples of 23, so only genuine replies carry a `true` result
+    first, second = request_with_sequence(23), request_with_sequence(46)
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    sock.sendall((HTTP_HEADERS % len(first)).encode() + first + garbage +
+                 (HTTP_HEADERS % len(second)).encode() + second)
+    replies = read_http_replies(sock)
+    sock.close()
+
+    assert replies and replies[0] == {'jsonrpc': '2.0', 'id': 23, 'result': True}, replies
+    # After that, the garbage may be refused and the second request may be lost
+    # with the connection, but nothing else may be answered
+    for reply in replies[1:]:
+        if 'error' in reply:
+            continue
+        assert reply == {'jsonrpc': '2.0', 'id': 46, 'result': True}, f'Bogus reply to garbage: {replies}'
+    assert [reply.get('id') for reply in replies].count(46) <= 1, replies
+
+
+@requires('validate_session')
+def test_method_name_exact_match():
+    client = ClientGeneric()
+    params = {'user_id': 23, 'session_id': 0}
+    assert client({'method': 'validate_session', 'params': params,
+                   'jsonrpc': '2.0', 'id': 0})['result'] is True
+
+    # Routing is case-sensitive and whitespace-strict, like any other string comparison
+    for method in ['Validate_Session', 'VALIDATE_SESSION', 'validate_session ',
+                   ' validate_session', 'validate_session\n', 'validate_session\u0000', '\u0000validate_session']:
+        response = client({'method': method, 'params': params, 'jsonrpc': '2.0', 'id': 0})
+        assert response.get('error', {}).get('code') == -32601, f'{method!r} was routed: {response}'
+
+    # Degenerate names may also be refused as malformed requests
+    for method in ['', ' ', '\t\n']:
+        response = client({'method': method, 'params': params, 'jsonrpc': '2.0', 'id': 0})
+        assert response.get('error', {}).get('code') in (-32600, -32601), f'{method!r} was routed: {response}'
+
+
+@requires('validate_session')
+def test_reused_source_tuple(count_cycles: int = 1000):
+    # Aborting with a RST skips TIME_WAIT, so the very same 4-tuple can be
+    # connected again right away, while the server may still track the old one
+    local_port = None
+    misbehaved = []
+    for cycle in range(count_cycles):
+        sock = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
+        sock.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
+        sock.bind(('127.0.0.1', local_port or 0))
+        local_port = sock.getsockname()[1]
+        try:
+            sock.connect(('127.0.0.1', 8545))
+            sock.send(request_with_sequence(cycle))
+            response = JSONStream(sock).next()
+            if response is None or response.get('id') != cycle:
+                misbehaved.append((cycle, f'replied {response}'))
+        except OSError as e:
+            misbehaved.append((cycle, repr(e)))
+        sock.setsockopt(socket.SOL_SOCKET, socket.SO_LINGER, struct.pack('ii', 1, 0))
+        sock.close()
+
+    assert not misbehaved, \
+        f'{len(misbehaved)} of {count_cycles} connections from port {local_port} misbehaved, ' \
+        f'first at cycles {[cycle for cycle, _ in misbehaved[:10]]}: {misbehaved[0][1]}'
+
+
+# Sizes around which requests are probed, override with comma-separated byte counts
+BOUNDARY_SIZES = [int(size) for size in os.environ.get(
+    'UCALL_BOUNDARY_SIZES', '1024,4096,8192,16384,65536,1048576').split(',')]
+
+
+def echo_of_exact_size(size: int, framing: str, id: int) -> tuple:
+    """Builds an `echo` request taking exactly `size` bytes on the wire, returning it with the echoed data"""
+    def build(data: str, spaces: int) -> bytes:
+        body = f'{{"jsonrpc":"2.0","method":"echo","params":{{"data":"{data}"}},{" " * spaces}"id":{id}}}'.encode()
+        return (HTTP_HEADERS % len(body)).encode() + body if framing == 'http' else body
+
+    # Base64 only comes in quads, JSON whitespace fills the remainder
+    data_length = max(0, (size - len(build('', 0))) // 4 * 4)
+    spaces = 0
+    while len(build('A' * data_length, spaces)) != size:
+        spaces += size - len(build('A' * data_length, spaces))
+        if spaces < 0:
+            data_length, spaces = data_length - 4, 0
+    data = base64.b64encode(random.Random(size).randbytes(data_length // 4 * 3)).decode()
+    return build(data, spaces), data
+
+
+@requires('echo')
+@pytest.mark.parametrize('framing', ['raw', 'http'])
+def test_buffer_boundaries(framing: str):
+    for boundary in BOUNDARY_SIZES:
+        for size in [boundary - 1, boundary, boundary + 1]:
+            request, data = echo_of_exact_size(size, framing, size)
+            assert len(request) == size
+            sock = make_tcp_socket('127.0.0.1', 8545)
+            sock.sendall(request)
+            if framing == 'http':
+                response = json.loads(read_http_reply(sock)[1])
+            else:
+                response = JSONStream(sock).next()
+            sock.close()
+            assert response is not None, f'Connection closed on a {size} byte request'
+            assert response.get('id') == size and response.get('result') == data, \
+                f'Wrong reply to a {size} byte request: {str(response)[:200]}'
+
+
+def decoded_http_body(head: bytes, content: bytes) -> bytes:
+    encoding = re.search(rb'Content-Encoding:\s*([^\r\n]*)', head, re.IGNORECASE)
+    if encoding is None or encoding.group(1).strip().lower() == b'identity':
+        return content
+    assert encoding.group(1).strip().lower() == b'gzip', f'Unexpected encoding: {head!r}'
+    return gzip.decompress(content)
+
+
+@requires('validate_session')
+def test_accept_encoding_ignored_or_honored():
+    body = request_with_sequence(23)
+    advertised = 'Accept-Encoding: gzip, deflate\r\n'
+    assert advertised in HTTP_HEADERS
+    variants = {
+        'gzip, deflate': HTTP_HEADERS,
+        'br, zstd': HTTP_HEADERS.replace(advertised, 'Accept-Encoding: br, zstd\r\n'),
+        'none': HTTP_HEADERS.replace(advertised, ''),
+    }
+    for name, headers in variants.items():
+        head, content = http_exchange(body, headers)
+        if name == 'br, zstd':
+            # Neither is supported, and gzip wasn't advertised as acceptable
+            assert not re.search(rb'Content-Encoding:\s*(?!identity)', head, re.IGNORECASE), head
+        response = json.loads(decoded_http_body(head, content))
+        assert response == {'jsonrpc': '2.0', 'id': 23, 'result': True}, \
+            f'Accept-Encoding {name} changed the reply: {response}'
+
+
+def assert_nothing_more(sock: socket.socket, leftover: bytes, what: str) -> None:
+    """Checks no bytes follow a complete reply, allowing the server to close the connection"""
+    assert not leftover.strip(), f'Extra bytes after the reply to {what}: {leftover[:200]!r}'
+    sock.settimeout(0.2)
+    try:
+        extra = sock.recv(4096)
+    except (TimeoutError, socket.timeout):
+        return
+    assert not extra, f'Extra bytes after the reply to {what}: {extra[:200]!r}'
+
+
+@requires('validate_session')
+@pytest.mark.parametrize('framing', ['raw', 'http'])
+def test_single_reply_per_request(framing: str):
+    bodies = {
+        'a success': request_with_sequence(23),
+        'a missing method': b'{"jsonrpc":"2.0","method":"sumsum","params":{},"id":1}',
+        'a missing param': b'{"jsonrpc":"2.0","method":"validate_session","params":{"user_id":2},"id":2}',
+        'a wrong param type': b'{"jsonrpc":"2.0","method":"validate_session","params":{"user_id":"2","session_id":1},"id":3}',
+    }
+    if framing == 'http':
+        # Raw frames have no length, so a malformed one may also wait for more bytes
+        bodies['malformed JSON'] = b'{"jsonrpc":"2.0","method":,"id":4}'
+    for what, body in bodies.items():
+        sock = make_tcp_socket('127.0.0.1', 8545)
+        if framing == 'http':
+            sock.sendall((HTTP_HEADERS % len(body)).encode() + body)
+            head, content = read_http_reply(sock)
+            length = int(re.search(rb'Content-Length:\s*(\d+)', head, re.IGNORECASE).group(1))
+            content, leftover = content[:length], content[length:]
+            response = json.loads(content)
+        else:
+            sock.sendall(body)
+            stream = JSONStream(sock)
+            response = stream.next()
+            leftover = stream.text[stream.offset:].encode()
+        assert isinstance(response, dict), f'Reply to {what} is not a single object: {response}'
+        assert ('result' in response) != ('error' in response), f'Reply to {what}: {response}'
+        assert_nothing_more(sock, leftover, what)
+        sock.close()
+
+
+def latency_budget(name: str, default_ms: float) -> float:
+    """Median latency budget in seconds, overridden with `UCALL_BUDGET_<NAME>_MS`"""
+    return float(os.environ.get(f'UCALL_BUDGET_{name.upper()}_MS', default_ms)) / 1e3
+
+
+@pytest.mark.parametrize('name,body,default_ms', [
+    pytest.param('echo_32b', b'{"jsonrpc":"2.0","method":"echo","params":{"data":"' + b'A' * 32 + b'"},"id":0}', 2,
+                 marks=requires('echo')),
+    ('method_missing', b'{"jsonrpc":"2.0","method":"sumsum","params":{},"id":0}', 2),
+    pytest.param('param_missing', b'{"jsonrpc":"2.0","method":"validate_session","params":{"user_id":2},"id":0}', 2,
+                 marks=requires('validate_session')),
+], ids=['echo_32b', 'method_missing', 'param_missing'])
+def test_latency_budget(name: str, body: bytes, default_ms: float, count_samples: int = 100):
+    # Functional but pathologically slow paths, like errors taking 200 ms, pass every other test
+    budget = latency_budget(name, default_ms)
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    sock.setsockopt(socket.IPPROTO_TCP, socket.TCP_NODELAY, 1)
+    stream = JSONStream(sock)
+    took = []
+    for _ in range(count_samples):
+        sent = time.perf_counter()
+        sock.sendall(body)
+        assert stream.next() is not None, 'Connection closed without a reply'
+        took.append(time.perf_counter() - sent)
+    sock.close()
+
+    median = statistics.median(took)
+    assert median <= budget, \
+        f'Median {name} latency of {median * 1e3:.2f} ms exceeds the {budget * 1e3:.2f} ms budget'
+
+
+@requires('echo_text')
+def test_utf8_boundaries():
+    session = requests.Session()
+    def post(body: bytes) -> object:
+        reply = session.post('http://127.0.0.1:8545/', data=body,
+                             headers={'Content-Type': 'application/json'})
+        return strict_json(reply.content)
+
+    # The first and last code points of every encoded length, and the replacement character itself
+    for text in ['\x7f', '\x80', '\u07ff', '\u0800', '\ufffd', '\uffff', '\U00010000', '\U0010ffff',
+                 'mixed \x7f\x80\u07ff\u0800\uffff\U00010000 text']:
+        for raw in [True, False]:
+            body = json.dumps({'jsonrpc': '2.0', 'method': 'echo_text', 'params': {'text': text}, 'id': 0},
+                              ensure_ascii=not raw).encode()
+            response = post(body)
+            assert response.get('result') == text, f'{text!r} sent {"raw" if raw else "escaped"}: {response}'
+
+    # Overlong, surrogate, truncated and never-valid sequences are rejected, not passed through or replaced
+    for invalid in [b'\xc0\xaf', b'\xe0\x80\xaf', b'\xed\xa0\x80', b'\xe2\x82', b'\xff', b'\x80']:
+        body = b'{"jsonrpc":"2.0","method":"echo_text","params":{"text":"a' + invalid + b'b"},"id":0}'
+        response = post(body)
+        assert response.get('error', {}).get('code') in (-32700, -32600, -32602), \
+            f'Invalid UTF-8 {invalid!r} was accepted: {response}'
+
+
+def median_exchange_latency(count_samples: int = 500) -> float:
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    sock.setsockopt(socket.IPPROTO_TCP, socket.TCP_NODELAY, 1)
+    stream = JSONStream(sock)
+    took = []
+    for sequence in range(count_samples):
+        sent = time.perf_counter()
+        sock.sendall(request_with_sequence(sequence))
+        assert_sequence_reply(stream, stream.next(), sequence)
+        took.append(time.perf_counter() - sent)
+    sock.close()
+    return statistics.median(took)
+
+
+def server_resources() -> tuple:
+    """Open descriptors and resident kilobytes of the server named by `UCALL_SERVER_PID`, if any"""
+    pid = os.environ.get('UCALL_SERVER_PID')
+    if not pid:
+        return None
+    with open(f'/proc/{pid}/status') as status:
+        rss = next(int(line.split()[1]) for line in status if line.startswith('VmRSS:'))
+    return len(os.listdir(f'/proc/{pid}/fd')), rss
+
+
+def connect_send_close(count_connections: int) -> int:
+    """Writes a full request on every connection and closes it unread, like a misconfigured health check"""
+    request = request_with_sequence(0)
+    refused = 0
+    for _ in range(count_connections):
+        try:
+            sock = make_tcp_socket('127.0.0.1', 8545)
+        except OSError:
+            refused += 1
+            continue
+        sock.sendall(request)
+        sock.close()
+    return refused
+
+
+@requires('validate_session')
+def test_connect_send_close_storm(count_threads: int = 16, count_connections: int = 1000):
+    # No method has side effects, so how many of the unread requests ran can't be observed
+    latency_before = median_exchange_latency()
+    resources_before = server_resources()
+    with ThreadPoolExecutor(count_threads) as pool:
+        refused = sum(pool.map(connect_send_close, [count_connections] * count_threads))
+    if refused:
+        print(f'{refused} of {count_threads * count_connections} connections were refused during the storm')
+    time.sleep(1)
+
+    latency_after = median_exchange_latency()
+    assert latency_after <= max(latency_before * 2, latency_before + 200e-6), \
+        f'Median latency grew from {latency_before * 1e6:.0f} us to {latency_after * 1e6:.0f} us after the storm'
+    if resources_before:
+        fds_before, rss_before = resources_before
+        fds_after, rss_after = server_resources()
+        assert fds_after <= fds_before + 16, f'Server descriptors grew from {fds_before} to {fds_after}'
+        assert rss_after <= rss_before * 1.5 + 16 * 1024, f'Server RSS grew from {rss_before} KB to {rss_after} KB'
+
+
+@requires('validate_session')
+@pytest.mark.parametrize('request_', [
+    {'method': 'validate_session', 'params': [2, 2], 'id': 0},
+    {'method': 'validate_session', 'params': {'user_id': 2, 'session_id': 2}, 'id': 0},
+    {'jsonrpc': '1.0', 'method': 'validate_session', 'params': [2, 2], 'id': 0},
+    {'jsonrpc': 2.0, 'method': 'validate_session', 'params': [2, 2], 'id': 0},
+    {'jsonrpc': None, 'method': 'validate_session', 'params': [2, 2], 'id': 0},
+], ids=['no_version_positional', 'no_version_named', 'version_1_0', 'version_as_number', 'version_null'])
+def test_jsonrpc_1_0_rejected(request_: dict):
+    # Only 2.0 is supported, so legacy requests are refused rather than coerced
+    response = ClientGeneric()(request_)
+    assert 'result' not in response, f'Served a 1.0-style request: {response}'
+    assert response.get('error', {}).get('code') == -32600, response
+
+
+@requires('validate_session')
+def test_jsonrpc_1_0_in_batch():
+    legacy = {'method': 'validate_session', 'params': [2, 2], 'id': 0}
+    modern = {'jsonrpc': '2.0', 'method': 'validate_session', 'params': {'user_id': 2, 'session_id': 2}, 'id': 1}
+    response = ClientGeneric()([legacy, modern])
+    assert isinstance(response, list) and len(response) == 2, response
+    assert {'jsonrpc': '2.0', 'id': 1, 'result': True} in response, f'The 2.0 request was not served: {response}'
+    rejected = [reply for reply in response if reply.get('id') != 1]
+    assert rejected[0].get('error', {}).get('code') == -32600, response
+
+
+@requires('validate_session')
+def test_framing_detected_per_message():
+    # Every packet is classified on its own, so a connection may switch back and forth
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    skip_unless_keep_alive(sock)
+    for sequence, http in [(23, False), (46, True), (69, False), (92, True)]:
+        body = request_with_sequence(sequence)
+        if http:
+            sock.sendall((HTTP_HEADERS % len(body)).encode() + body)
+            head, content = read_http_reply(sock)
+            assert head.startswith(b'HTTP/1.1 200'), head
+            response = json.loads(content)
+        else:
+            sock.sendall(body)
+            stream = JSONStream(sock)
+            response = stream.next()
+            assert not stream.text.lstrip().startswith('HTTP'), stream.text
+        assert response == {'jsonrpc': '2.0', 'id': sequence, 'result': True}, \
+            f'Wrong reply to the {"HTTP" if http else "raw"} request {sequence}: {response}'
+    sock.close()
+
+
+@pytest.mark.parametrize('body', [
+    b'{"jsonrpc":"2.0","method":"sumsum","params":{},"id":0}',
+    pytest.param(b'{"jsonrpc":"2.0","method":"validate_session","params":{"user_id":2},"id":0}',
+                 marks=requires('validate_session')),
+    pytest.param(b'{"method":"validate_session","params":{"user_id":2,"session_id":2},"id":0}',
+                 marks=requires('validate_session')),
+    b'{"jsonrpc":"2.0","method":,"id":0}',
+    # Fails inside the Python method, decoding the identity as UTF-8
+    pytest.param(b'{"jsonrpc":"2.0","method":"transform","params":{"age":20,"name":"x","value":1,"identity":"/w=="},"id":0}',
+                 marks=requires('transform')),
+], ids=['method_missing', 'param_missing', 'version_missing', 'malformed_json', 'python_exception'])
+def test_error_object_members(body: bytes):
+    # No error path attaches `data`, and it is absent rather than `null`
+    response = strict_json(requests.post('http://127.0.0.1:8545/', data=body,
+                                         headers={'Content-Type': 'application/json'}).content)
+    error = response.get('error')
+    assert isinstance(error, dict), f'Not an error: {response}'
+    assert set(error) == {'code', 'message'}, f'Unexpected error members: {error}'
+    assert isinstance(error['code'], int) and isinstance(error['message'], str), error
+
+
+@pytest.mark.parametrize('body,code', [
+    (b'', -32700),
+    (b' ', -32700),
+    (b'""', -32600),
+], ids=['empty', 'space', 'empty_string'])
+def test_empty_bodies(body: bytes, code: int):
+    # Load balancer health checks often POST nothing at all
+    head, content = http_exchange(body)
+    length = int(re.search(rb'Content-Length:\s*(\d+)', head, re.IGNORECASE).group(1))
+    response = json.loads(content[:length])
+    assert response.get('error', {}).get('code') == code, f'Unexpected reply to {body!r}: {response}'
+
+
+@requires('validate_session')
+def test_zero_byte_write():
+    # An empty write puts nothing on the wire, so the next request must be answered as usual
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    assert sock.send(b'') == 0
+    sock.sendall(request_with_sequence(23))
+    response = JSONStream(sock).next()
+    assert response == {'jsonrpc': '2.0', 'id': 23, 'result': True}, response
+    sock.close()
+
+
+@requires('validate_session')
+@pytest.mark.parametrize('id', ['null', '0', '', 'a"b\\c', 'tab\there', 0], ids=[
+    'string_null', 'string_zero', 'empty_string', 'json_special', 'control_character', 'integer_zero'])
+def test_confusable_ids(id):
+    # String IDs must come back as the same strings, not spliced into the reply as raw tokens
+    response = ClientGeneric()({'jsonrpc': '2.0', 'method': 'validate_session',
+                                'params': {'user_id': 2, 'session_id': 2}, 'id': id})
+    assert 'id' in response and type(response['id']) is type(id) and response['id'] == id, \
+        f'Sent the ID {id!r}, got {response}'
+    assert response.get('result') is True, response
+
+
+@requires('validate_session')
+def test_repeated_id():
+    # IDs are only echoed, so reusing one is the client's problem and every request is still served
+    request = {'jsonrpc': '2.0', 'method': 'validate_session', 'params': {'user_id': 2, 'session_id': 2}, 'id': 'same'}
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    skip_unless_keep_alive(sock)
+    stream = JSONStream(sock)
+    for _ in range(2):
+        sock.sendall(json.dumps(request).encode())
+        assert stream.next() == {'jsonrpc': '2.0', 'id': 'same', 'result': True}
+    sock.close()
+    response = ClientGeneric()([request, request])
+    assert response == [{'jsonrpc': '2.0', 'id': 'same', 'result': True}] * 2, response
+
+
+def classify_reply(send) -> str:
+    """Runs a probe, describing the reply as `result`, the error code, or the lack of any reply"""
+    try:
+        response = send()
+    except (TimeoutError, socket.timeout, AssertionError, ConnectionError):
+        return 'no reply'
+    except ValueError:
+        return 'unparsable reply'
+    if isinstance(response, dict) and 'error' in response:
+        return f'error {response["error"].get("code")}'
+    return 'result' if isinstance(response, dict) and 'result' in response else f'unexpected {response!r:.40}'
+
+
+def http_probe(request: bytes) -> object:
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    sock.sendall(request)
+    head, content = read_http_reply(sock)
+    sock.close()
+    length = int(re.search(rb'Content-Length:\s*(\d+)', head, re.IGNORECASE).group(1))
+    return json.loads(content[:length])
+
+
+# Implementation choices rather than bugs, which a strict suite shouldn't fail on.
+# Each probe maps a short answer for the `-matrix` report, clients pick their settings from it.
+# Method name case and JSON-RPC 1.0 are also enforced by the strict tests of this build,
+# the matrix only records what a server does with them.
+GRAY_AREAS = {
+    'HTTP status of RPC errors': lambda: http_exchange(
+        b'{"jsonrpc":"2.0","method":"sumsum","params":{},"id":0}')[0].split(b'\r\n')[0].decode(),
+    'Method names in other case': lambda: classify_reply(lambda: ClientGeneric()(
+        {'jsonrpc': '2.0', 'method': 'VALIDATE_SESSION', 'params': {'user_id': 2, 'session_id': 2}, 'id': 0})),
+    'Chunked request bodies': lambda: classify_reply(lambda: http_probe(
+        HTTP_HEADERS.replace('Content-Length: %i', 'Transfer-Encoding: chunked').encode() +
+        b'%x\r\n%s\r\n0\r\n\r\n' % (len(request_with_sequence(23)), request_with_sequence(23)))),
+    'JSON-RPC 1.0 requests': lambda: classify_reply(lambda: ClientGeneric()(
+        {'method': 'validate_session', 'params': {'user_id': 2, 'session_id': 2}, 'id': 0})),
+    'Zero-padded Content-Length': lambda: classify_reply(lambda: http_probe(
+        (HTTP_HEADERS.replace('%i', '000%i') % len(request_with_sequence(23))).encode() + request_with_sequence(23))),
+}
+
+
+def behavior_matrix() -> dict:
+    """Runs every gray-area probe against the server, never failing on the answers"""
+    matrix = {}
+    for name, probe in GRAY_AREAS.items():
+        try:
+            matrix[name] = probe()
+        except Exception as e:
+            matrix[name] = f'probe failed: {e}'
+    return matrix
+
+
+# Only the HTTP status probe calls a method every profile has
+@pytest.mark.parametrize('area', [
+    name if name == 'HTTP status of RPC errors' else pytest.param(name, marks=requires('validate_session'))
+    for name in GRAY_AREAS
+], ids=lambda name: name.lower().replace(' ', '_').replace('-', '_'))
+def test_gray_area(area: str, record_property):
+    # Records the behavior in the JUnit report instead of judging it, so every run keeps the matrix
+    behavior = GRAY_AREAS[area]()
+    record_property(area, behavior)
+    print(f'{area}: {behavior}')
+
+
+def print_behavior_matrix(path: str = None) -> None:
+    matrix = behavior_matrix()
+    width = max(map(len, matrix))
+    for name, behavior in matrix.items():
+        print(f'{name:<{width}}  {behavior}')
+    document = {'server': '127.0.0.1:8545', 'time': time.strftime('%Y-%m-%dT%H:%M:%S%z'), 'behaviors': matrix}
+    if path:
+        with open(path, 'w') as file:
+            json.dump(document, file, indent=4)
+    else:
+        print(json.dumps(document, indent=4))
+
+
+@requires('echo_text')
+@pytest.mark.parametrize('framing', ['raw', 'http'])
+def test_no_reply_before_request_completes(framing: str, size: int = 64 * 1024):
+    # Speculative replies to partial input would corrupt pipelined streams
+    text = ''.join(random.Random(size).choices('abcdefghijklmnopqrstuvwxyz', k=size))
+    body = json.dumps({'jsonrpc': '2.0', 'method': 'echo_text', 'params': {'text': text}, 'id': 0}).encode()
+    if framing == 'http':
+        body = (HTTP_HEADERS % len(body)).encode() + body
+    cut = len(body) * 9 // 10
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    sock.sendall(body[:cut])
+    sock.settimeout(2)
+    try:
+        early = sock.recv(4096)
+    except (TimeoutError, socket.timeout):
+        early = b''
+    assert not early, f'Got a reply to 90% of the request: {early[:200]!r}'
+
+    sock.sendall(body[cut:])
+    if framing == 'http':
+        head, content = read_http_reply(sock)
+        length = int(re.search(rb'Content-Length:\s*(\d+)', head, re.IGNORECASE).group(1))
+        response = json.loads(content[:length])
+    else:
+        response = JSONStream(sock).next()
+    assert response == {'jsonrpc': '2.0', 'id': 0, 'result': text}, f'Wrong reply: {str(response)[:200]}'
+    sock.close()
+
+
+SENTINEL_PATTERN = re.compile(r'<<sentinel:\d+:\d+:[0-9a-f]{8}>>')
+
+
+def sentinel_echoes(connection: int, count_windows: int = 50, window: int = 32) -> None:
+    """Pipelines windows of large echoes, checking every reply frame carries its own sentinel only"""
+    sock = make_tcp_socket('127.0.0.1', 8545)
+    skip_unless_keep_alive(sock)
+    stream = JSONStream(sock)
+    padding = 'x' * 4000
+    for cycle in range(count_windows):
+        outstanding = {}
+        for id in range(cycle * window, (cycle + 1) * window):
+            sentinel = f'<<sentinel:{connection}:{id}:{random.getrandbits(32):08x}>>'
+            outstanding[id] = f'{padding[:id % 4000]}{sentinel}{padding[:4000 - id % 4000]}'
+            sock.sendall(json.dumps({'jsonrpc': '2.0', 'method': 'echo_text',
+                                     'params': {'text': outstanding[id]}, 'id': id}).encode())
+        while outstanding:
+            begin = stream.offset
+            response = stream.next()
+            frame = stream.text[begin:stream.offset]
+            context = f'on connection {connection}: {stream.neighborhood(8192)!r}'
+            assert response is not None, f'Connection closed with {len(outstanding)} replies pending {context}'
+            sentinels = SENTINEL_PATTERN.findall(frame)
+            assert len(sentinels) == 1, f'Frame with {len(sentinels)} sentinels {context}'
+            assert response.get('id') in outstanding, f'Reply to no outstanding request {context}'
+            assert response.get('result') == outstanding.pop(response['id']), f'Corrupted echo {context}'
+    sock.close()
+
+
+@requires('echo_text')
+def test_replies_never_interleave(count_connections: int = 8):
+    with ThreadPoolExecutor(count_connections) as executor:
+        list(executor.map(sentinel_echoes, range(count_connections)))
+
+
+@requires('set', 'get')
+def test_redis_set_get():
+    client = ClientGeneric()
+    key = f'key-{random.getrandbits(64):016x}'
+    # Quotes, backslashes and control characters must come back as they were set
+    value = 'say "hi"\\\n\x01'
+    get = {'jsonrpc': '2.0', 'method': 'get', 'params': {'key': key}, 'id': 1}
+    assert client(get) == {'jsonrpc': '2.0', 'id': 1, 'result': None}
+    response = client({'jsonrpc': '2.0', 'method': 'set', 'params': {'key': key, 'value': value}, 'id': 2})
+    assert response == {'jsonrpc': '2.0', 'id': 2, 'result': 'OK'}
+    assert client(get) == {'jsonrpc': '2.0', 'id': 1, 'result': value}
+    response = client({'jsonrpc': '2.0', 'method': 'set', 'params': {'key': key}, 'id': 3})
+    assert response['error']['code'] == -32602, response
+
+
 # def test_transform():
 #     client = ClientGeneric()
 #     identity = 'This is an identity'
@@ -70,30 +1117,35 @@ def test_uniform_batches():
 #     assert new_id == identity + f'_Eager'
 
 
+@requires('validate_session')
 def test_normal():
     client = Client()
     response = client.validate_session(user_id=2, session_id=2)
     assert response.json == True
 
 
+@requires('validate_session')
 def test_normal_positional():
     client = Client()
     response = client.validate_session(2, 2)
     assert response.json == True
 
 
+@requires('validate_session')
 def test_normal_tls():
     client = ClientTLS(allow_self_signed=True)
     response = client.validate_session(user_id=2, session_id=2)
     assert response.json == True
 
 
+@requires('validate_session')
 def test_normal_positional_tls():
     client = ClientTLS(allow_self_signed=True)
     response = client.validate_session(2, 2)
     assert response.json == True
 
 
+@requires('validate_session')
 def test_notification():
     client = ClientGeneric()
     response = client({
@@ -115,6 +1167,7 @@ def test_method_missing():
     assert response['error']['code'] == -32601
 
 
+@requires('validate_session')
 def test_param_missing():
     client = ClientGeneric()
     response = client({
@@ -126,6 +1179,7 @@ def test_param_missing():
     assert response['error']['code'] == -32602
 
 
+@requires('validate_session')
 def test_param_type():
     client = ClientGeneric()
     response = client({
@@ -137,6 +1191,7 @@ def test_param_type():
     assert response['error']['code'] == -32602
 
 
+@requires('validate_session')
 def test_non_uniform_batch():
     a = 2
     b = 2
@@ -152,6 +1207,7 @@ def test_non_uniform_batch():
     ])
 
 
+@requires('validate_all_sessions')
 def test_numpy():
     a = np.random.randint(0, 101, size=(1, 3, 10))
     b = np.random.randint(0, 101, size=(1, 3, 10))
@@ -167,6 +1223,7 @@ def test_numpy():
     assert np.array_equal(response.numpy, res)
 
 
+@requires('rotate_avatar')
 def test_pillow():
     img = Image.open('examples/login/original.jpg')
     res = img.rotate(45)
@@ -178,6 +1235,7 @@ def test_pillow():
     assert np.array_equal(ar1, ar2)
 
 
+@requires('validate_all_sessions')
 def test_numpy_tls():
     a = np.random.randint(0, 101, size=(1, 3, 10))
     b = np.random.randint(0, 101, size=(1, 3, 10))
@@ -193,6 +1251,7 @@ def test_numpy_tls():
     assert np.array_equal(response.numpy, res)
 
 
+@requires('rotate_avatar')
 def test_pillow_tls():
     img = Image.open('examples/login/original.jpg')
     res = img.rotate(45)
@@ -205,6 +1264,11 @@ def test_pillow_tls():
 
 
 if __name__ == '__main__':
+    # Describe the gray areas with `python examples/test.py -matrix [report.json]`
+    if '-matrix' in sys.argv:
+        arguments = sys.argv[sys.argv.index('-matrix') + 1:]
+        print_behavior_matrix(arguments[0] if arguments else None)
+        sys.exit(0)
     test_normal()
     test_normal_positional()
     # test_normal_tls()
@@ -0,0 +1,3 @@
+module github.com/unum-cloud/ucall
+
+go 1.21
@@ -1,4 +1,5 @@
 #pragma once
+#include <cstring> // `std::memcpy`
 #include <variant>
 
 #include <picohttpparser.h>
@@ -14,6 +15,7 @@ namespace sjd = sj::dom;
 struct scratch_space_t {
     char json_pointer[json_pointer_capacity_k]{};
     char printed_int_id[max_integer_length_k]{};
+    char printed_string_id[max_string_id_length_k]{};
 
     sjd::parser parser{};
     sjd::element tree{};
@@ -88,7 +90,33 @@ inline std::variant<named_callback_t, default_error_t> find_callback(named_callb
         return default_error_t{-32600, "Parameters can only be passed in arrays or objects."};
 
     if (id.is_string()) {
-        scratch.dynamic_id = id.get_string().value_unsafe();
+        // The ID is copied into the reply as is, so it must be quoted and escaped again,
+        // or the string "0" would come back as the number 0, and the string "null" as null.
+        std::string_view id_string = id.get_string().value_unsafe();
+        char* code = &scratch.printed_string_id[0];
+        std::size_t code_len = 0;
+        code[code_len++] = '"';
+        for (char c : id_string) {
+            // Leave space for the escaped character and the closing quote
+            std::size_t escaped_len = c == '"' || c == '\\' ? 2 : static_cast<unsigned char>(c) < 0x20 ? 6 : 1;
+            if (code_len + escaped_len + 1 > max_string_id_length_k) {
+                scratch.dynamic_id = "null";
+                return default_error_t{-32600, "The request ID is too long."};
+            }
+            if (c == '"' || c == '\\') {
+                code[code_len++] = '\\';
+                code[code_len++] = c;
+            } else if (static_cast<unsigned char>(c) < 0x20) {
+                char const* hex_digits = "0123456789abcdef";
+                std::memcpy(code + code_len, "\\u00", 4);
+                code[code_len + 4] = hex_digits[c >> 4];
+                code[code_len + 5] = hex_digits[c & 0xF];
+                code_len += 6;
+            } else
+                code[code_len++] = c;
+        }
+        code[code_len++] = '"';
+        scratch.dynamic_id = std::string_view(code, code_len);
     } else if (id.is_int64() || id.is_uint64()) {
         char* code = &scratch.printed_int_id[0];
         std::to_chars_result res = std::to_chars(code, code + max_integer_length_k, id.get_int64().value_unsafe());
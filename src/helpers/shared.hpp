@@ -33,6 +33,9 @@ static constexpr std::size_t json_pointer_capacity_k = 256;
 /// @brief Number of bytes in a printed integer.
 /// Used either for error codes, or for request IDs.
 static constexpr std::size_t max_integer_length_k = 32;
+/// @brief Number of bytes in a quoted and escaped string request ID.
+/// IDs that don't fit, quotes included, are rejected with -32600.
+static constexpr std::size_t max_string_id_length_k = 256;
 /// @brief Needed for largest-register-aligned memory addressing.
 static constexpr std::size_t align_k = 64;
 /// @brief Accessing real time from user-space is very expensive.
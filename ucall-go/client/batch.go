@@ -0,0 +1,255 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Batch collects calls and notifications to send as one JSON-RPC batch.
+type Batch struct {
+	client   *Client
+	requests []request
+}
+
+// BatchResult is the outcome of one entry of a batch, either a result or an error.
+type BatchResult struct {
+	Result json.RawMessage
+	Err    error
+}
+
+// NewBatch starts an empty batch on this client.
+func (c *Client) NewBatch() *Batch {
+	return &Batch{client: c}
+}
+
+// Add appends a call, whose result is expected in the reply.
+func (b *Batch) Add(method string, params any) *Batch {
+	b.client.nextID++
+	id := b.client.nextID
+	b.requests = append(b.requests, request{JSONRPC: "2.0", Method: method, Params: params, ID: &id})
+	return b
+}
+
+// Notify appends a notification, which the server never answers.
+func (b *Batch) Notify(method string, params any) *Batch {
+	b.requests = append(b.requests, request{JSONRPC: "2.0", Method: method, Params: params})
+	return b
+}
+
+// Send submits the batch and waits for every call in it, within the context like
+// CallContext. Results come in the order entries were added, matched by id however
+// the server orders its replies, and notifications are left empty. A batch of
+// notifications alone doesn't wait for anything. Calls left without a reply get
+// errors of their own, along with any the server sent without ids.
+func (b *Batch) Send(ctx context.Context) (results []BatchResult, err error) {
+	if len(b.requests) == 0 {
+		return nil, nil
+	}
+	err = b.client.guard(ctx, func() error {
+		results, err = b.exchange()
+		return err
+	})
+	return results, err
+}
+
+func (b *Batch) exchange() ([]BatchResult, error) {
+	body, err := marshal(b.requests)
+	if err != nil {
+		return nil, err
+	}
+	positions := map[string]int{}
+	for position, entry := range b.requests {
+		if entry.ID != nil {
+			positions[fmt.Sprint(*entry.ID)] = position
+		}
+	}
+	if err := b.client.send(body); err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult, len(b.requests))
+	if len(positions) == 0 {
+		return results, nil
+	}
+
+	content, err := b.client.receive()
+	// Replies of calls pipelined before the batch may still come first
+	for err == nil && b.client.deliver(content) {
+		content, err = b.client.receive()
+	}
+	if err != nil {
+		return nil, err
+	}
+	replies := []response{}
+	if err := json.Unmarshal(content, &replies); err != nil {
+		// A batch rejected as a whole gets a single error object
+		single := response{}
+		if json.Unmarshal(content, &single) == nil && single.Error != nil {
+			return nil, single.Error
+		}
+		return nil, err
+	}
+	// Replies matching no entry leave the others' results intact, errors found
+	// before an entry is parsed don't carry its id
+	strays := []error{}
+	for _, reply := range replies {
+		position, known := positions[string(reply.ID)]
+		if !known {
+			if reply.Error != nil {
+				strays = append(strays, reply.Error)
+				continue
+			}
+			b.client.unmatched++
+			if b.client.Logger != nil {
+				b.client.Logger.Printf("ucall: skipped a batch reply with the id %s matching no entry", reply.ID)
+			}
+			continue
+		}
+		delete(positions, string(reply.ID))
+		if reply.Error != nil {
+			results[position].Err = reply.Error
+		} else {
+			results[position].Result = reply.Result
+		}
+	}
+	for id, position := range positions {
+		if len(strays) > 0 {
+			results[position].Err = fmt.Errorf("no reply for the id %s, the batch got errors without ids: %w", id, errors.Join(strays...))
+		} else {
+			results[position].Err = fmt.Errorf("no reply for the id %s", id)
+		}
+	}
+	return results, nil
+}
+
+// Request is one entry of CallBatch or EncodeBatch.
+type Request struct {
+	Method string
+	Params any
+}
+
+// EncodeBatch renders the calls as a JSON-RPC 2.0 batch, with ids counting up from
+// `firstID`, the way Batch sends them. Params are marshaled as is, like with Encode.
+func EncodeBatch(calls []Request, firstID int) ([]byte, error) {
+	requests := make([]request, len(calls))
+	for i, call := range calls {
+		id := firstID + i
+		requests[i] = request{JSONRPC: "2.0", Method: call.Method, Params: call.Params, ID: &id}
+	}
+	return marshal(requests)
+}
+
+// BatchOptions bound the sub-batches CallBatch splits its calls into.
+type BatchOptions struct {
+	// MaxBatchBytes limits the encoded size of a sub-batch, brackets and commas included.
+	// A single call larger than that is still sent, alone. Zero means no limit.
+	MaxBatchBytes int
+	// MaxBatchCount limits the calls in a sub-batch, zero means no limit.
+	MaxBatchCount int
+	// Parallelism is how many sub-batches are in flight at once, each on its own
+	// connection dialed for the occasion. Clients built with New send them one by one.
+	Parallelism int
+}
+
+// subBatch holds some of the calls of CallBatch, with their positions among all.
+type subBatch struct {
+	positions []int
+	requests  []request
+}
+
+// CallBatch sends any number of calls as batches within the limits of the options,
+// returning one result per call in their original order. A sub-batch that fails as
+// a whole, rejected by the server or lost with its connection, fails only its own
+// calls, and the returned error just summarizes such failures, along with the calls
+// whose params couldn't be encoded.
+func (c *Client) CallBatch(ctx context.Context, calls []Request, options BatchOptions) ([]BatchResult, error) {
+	results := make([]BatchResult, len(calls))
+	batches := []subBatch{}
+	current, size := subBatch{}, 0
+	unencoded := []error{}
+	for position, call := range calls {
+		c.nextID++
+		id := c.nextID
+		entry := request{JSONRPC: "2.0", Method: call.Method, Params: call.Params, ID: &id}
+		encoded, err := marshal(entry)
+		if err != nil {
+			results[position].Err = err
+			unencoded = append(unencoded, fmt.Errorf("call #%d couldn't be encoded: %w", position, err))
+			continue
+		}
+		// Brackets come with the first entry, a comma with every other one
+		if len(current.requests) > 0 {
+			full := options.MaxBatchCount > 0 && len(current.requests) == options.MaxBatchCount
+			large := options.MaxBatchBytes > 0 && size+1+len(encoded) > options.MaxBatchBytes
+			if full || large {
+				batches = append(batches, current)
+				current = subBatch{}
+			}
+		}
+		if len(current.requests) == 0 {
+			size = 2 + len(encoded)
+		} else {
+			size += 1 + len(encoded)
+		}
+		current.positions = append(current.positions, position)
+		current.requests = append(current.requests, entry)
+	}
+	if len(current.requests) > 0 {
+		batches = append(batches, current)
+	}
+	err := c.sendBatches(ctx, batches, results, options.Parallelism)
+	return results, errors.Join(append(unencoded, err)...)
+}
+
+// sendBatches sends the sub-batches over up to `parallelism` connections, this
+// client's own and freshly dialed ones.
+func (c *Client) sendBatches(ctx context.Context, batches []subBatch, results []BatchResult, parallelism int) error {
+	if c.addr == "" || parallelism < 1 {
+		parallelism = 1
+	}
+	parallelism = min(parallelism, len(batches))
+	pending := make(chan subBatch, len(batches))
+	for _, batch := range batches {
+		pending <- batch
+	}
+	close(pending)
+
+	failures := make(chan error, len(batches))
+	workers := sync.WaitGroup{}
+	for worker := 0; worker < parallelism; worker++ {
+		connection := c
+		if worker > 0 {
+			// Dialed by the first call, just like after an interruption
+			connection = &Client{HTTP: c.HTTP, Logger: c.Logger, addr: c.addr}
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if connection != c {
+				defer connection.Close()
+			}
+			for batch := range pending {
+				replies, err := (&Batch{client: connection, requests: batch.requests}).Send(ctx)
+				for i, position := range batch.positions {
+					if err != nil {
+						results[position].Err = err
+					} else {
+						results[position] = replies[i]
+					}
+				}
+				if err != nil {
+					failures <- fmt.Errorf("sub-batch of %d calls from #%d failed: %w", len(batch.positions), batch.positions[0], err)
+				}
+			}
+		}()
+	}
+	workers.Wait()
+	close(failures)
+	errs := []error{}
+	for err := range failures {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
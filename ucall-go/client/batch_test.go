@@ -0,0 +1,252 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	addr := serve(t, 0)
+	for _, framing := range []string{"raw", "http"} {
+		c, err := Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.HTTP = framing == "http"
+		batch := c.NewBatch()
+		userIDs := []int{2, 23, 3, 46, 4}
+		for _, userID := range userIDs {
+			batch.Add("validate_session", map[string]int{"user_id": userID, "session_id": 0})
+		}
+		batch.Notify("validate_session", map[string]int{"user_id": 23, "session_id": 0})
+		batch.Add("sumsum", nil)
+		results, err := batch.Send(context.Background())
+		if err != nil {
+			t.Fatalf("%s batch failed: %v", framing, err)
+		}
+		if len(results) != len(userIDs)+2 {
+			t.Fatalf("%s batch returned %d results", framing, len(results))
+		}
+		// The mock replies in reverse order, results must still follow the entries
+		for i, userID := range userIDs {
+			if expected := fmt.Sprint(userID%23 == 0); string(results[i].Result) != expected || results[i].Err != nil {
+				t.Fatalf("%s batch entry %d returned %s, %v, expected %s", framing, i, results[i].Result, results[i].Err, expected)
+			}
+		}
+		if notification := results[len(userIDs)]; notification.Result != nil || notification.Err != nil {
+			t.Fatalf("%s batch notification got %+v", framing, notification)
+		}
+		if results[len(userIDs)+1].Err == nil {
+			t.Fatalf("%s batch call of a missing method didn't fail", framing)
+		}
+		c.Close()
+	}
+}
+
+func TestBatchOfNotifications(t *testing.T) {
+	addr := serve(t, 0)
+	for _, framing := range []string{"raw", "http"} {
+		c, err := Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.HTTP = framing == "http"
+		results, err := c.NewBatch().
+			Notify("validate_session", map[string]int{"user_id": 1, "session_id": 0}).
+			Notify("validate_session", map[string]int{"user_id": 2, "session_id": 0}).
+			Send(context.Background())
+		if err != nil || len(results) != 2 {
+			t.Fatalf("%s batch of notifications returned %v, %v", framing, results, err)
+		}
+		// The empty array the server still sends must not be taken for the next reply
+		result, err := c.Call("validate_session", map[string]int{"user_id": 23, "session_id": 0})
+		if err != nil || string(result) != "true" {
+			t.Fatalf("%s call after notifications returned %s, %v", framing, result, err)
+		}
+		c.Close()
+	}
+}
+
+func sessions(count int) []Request {
+	calls := make([]Request, count)
+	for i := range calls {
+		calls[i] = Request{Method: "validate_session", Params: map[string]int{"user_id": i, "session_id": 0}}
+	}
+	return calls
+}
+
+func TestCallBatchFailingMiddle(t *testing.T) {
+	for _, parallelism := range []int{1, 4} {
+		c, err := Dial(serve(t, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls := sessions(30)
+		calls[15].Method = "fail_batch"
+		results, err := c.CallBatch(context.Background(), calls, BatchOptions{MaxBatchCount: 10, Parallelism: parallelism})
+		if err == nil || !strings.Contains(err.Error(), "-32603") {
+			t.Fatalf("expected the middle sub-batch failure to be reported, got %v", err)
+		}
+		for i, result := range results {
+			if failed := i >= 10 && i < 20; failed != (result.Err != nil) {
+				t.Fatalf("with %d connections call %d returned %s, %v", parallelism, i, result.Result, result.Err)
+			}
+			if expected := fmt.Sprint(i%23 == 0); result.Err == nil && string(result.Result) != expected {
+				t.Fatalf("with %d connections call %d returned %s, expected %s", parallelism, i, result.Result, expected)
+			}
+		}
+		c.Close()
+	}
+}
+
+// Connections dialed for parallel sub-batches log through the client's Logger.
+func TestCallBatchLogger(t *testing.T) {
+	c, err := Dial(serve(t, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	logged := strings.Builder{}
+	c.Logger = log.New(&logged, "", 0)
+	calls := sessions(8)
+	for i := 1; i < len(calls); i += 2 {
+		calls[i].Method = "stray"
+	}
+	results, err := c.CallBatch(context.Background(), calls, BatchOptions{MaxBatchCount: 2, Parallelism: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if stray := i%2 == 1; stray != (result.Err != nil) {
+			t.Fatalf("call %d returned %s, %v", i, result.Result, result.Err)
+		}
+	}
+	if lines := strings.Count(logged.String(), "id -1"); lines != 4 {
+		t.Fatalf("logged %d of the 4 stray replies: %q", lines, logged.String())
+	}
+}
+
+// encodedSizes predicts the entries of the next CallBatch, as ids are assigned in order.
+func encodedSizes(c *Client, calls []Request) []int {
+	sizes := []int{}
+	for i, call := range calls {
+		id := c.nextID + 1 + i
+		encoded, _ := marshal(request{JSONRPC: "2.0", Method: call.Method, Params: call.Params, ID: &id})
+		sizes = append(sizes, len(encoded))
+	}
+	return sizes
+}
+
+func TestCallBatchSizes(t *testing.T) {
+	addr, log := serveLogged(t, 0)
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	calls := sessions(12)
+	sizes := encodedSizes(c, calls)
+	// Exactly three entries fit, the fourth would exceed the limit by a byte
+	limit := 2 + sizes[0] + 1 + sizes[1] + 1 + sizes[2] + 1 + sizes[3] - 1
+	results, err := c.CallBatch(context.Background(), calls, BatchOptions{MaxBatchBytes: limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result.Err != nil || string(result.Result) != fmt.Sprint(i%23 == 0) {
+			t.Fatalf("call %d returned %s, %v", i, result.Result, result.Err)
+		}
+	}
+	expected := []int{}
+	for first := 0; first < len(sizes); first += 3 {
+		expected = append(expected, 2+sizes[first]+1+sizes[first+1]+1+sizes[first+2])
+	}
+	if fmt.Sprint(log.sizes) != fmt.Sprint(expected) {
+		t.Fatalf("sent batches of %v bytes, expected %v within %d", log.sizes, expected, limit)
+	}
+	for _, size := range log.sizes {
+		if size > limit {
+			t.Fatalf("a batch of %d bytes exceeds the limit of %d", size, limit)
+		}
+	}
+
+	// A limit of exactly four entries takes them all
+	log.sizes = nil
+	sizes = encodedSizes(c, calls[:4])
+	limit = 2 + sizes[0] + 1 + sizes[1] + 1 + sizes[2] + 1 + sizes[3]
+	if _, err := c.CallBatch(context.Background(), calls[:4], BatchOptions{MaxBatchBytes: limit}); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.sizes) != 1 || log.sizes[0] != limit {
+		t.Fatalf("sent batches of %v bytes within %d", log.sizes, limit)
+	}
+}
+
+func TestCallBatchUnencodable(t *testing.T) {
+	c, err := Dial(serve(t, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	calls := sessions(3)
+	calls[1].Params = map[string]any{"user_id": make(chan int)}
+	results, err := c.CallBatch(context.Background(), calls, BatchOptions{})
+	if err == nil || !strings.Contains(err.Error(), "call #1") {
+		t.Fatalf("an unencodable call went unreported, %v", err)
+	}
+	if results[1].Err == nil || results[0].Err != nil || results[2].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+}
+
+func TestEncodeBatch(t *testing.T) {
+	frame, err := EncodeBatch([]Request{
+		{Method: "validate_session", Params: json.RawMessage(`{"user_id":1,"session_id":2}`)},
+		{Method: "sumsum"},
+	}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"jsonrpc":"2.0","method":"validate_session","params":{"user_id":1,"session_id":2},"id":7},{"jsonrpc":"2.0","method":"sumsum","id":8}]`
+	if string(frame) != expected {
+		t.Fatalf("got %s, expected %s", frame, expected)
+	}
+}
+
+// Replies matching no entry, like errors for entries the server couldn't parse,
+// fail only the entries left without a reply.
+func TestBatchMixedReplies(t *testing.T) {
+	c, err := Dial(serve(t, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	logged := strings.Builder{}
+	c.Logger = log.New(&logged, "", 0)
+	results, err := c.NewBatch().
+		Add("validate_session", map[string]int{"user_id": 23, "session_id": 0}).
+		Add("null_id", nil).
+		Add("validate_session", map[string]int{"user_id": 2, "session_id": 0}).
+		Add("stray", nil).
+		Send(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(results[0].Result) != "true" || results[0].Err != nil || string(results[2].Result) != "false" || results[2].Err != nil {
+		t.Fatalf("matched entries returned %+v and %+v", results[0], results[2])
+	}
+	for _, failed := range []BatchResult{results[1], results[3]} {
+		rpcErr := &Error{}
+		if !errors.As(failed.Err, &rpcErr) || rpcErr.Code != InvalidRequest {
+			t.Fatalf("an entry without a reply returned %s, %v", failed.Result, failed.Err)
+		}
+	}
+	if c.Unmatched() != 1 || !strings.Contains(logged.String(), "id -1") {
+		t.Fatalf("skipped %d replies, logging %q", c.Unmatched(), logged.String())
+	}
+}
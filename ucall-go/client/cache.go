@@ -0,0 +1,247 @@
+package client
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize is the number of results kept without CacheOptions.Size.
+const defaultCacheSize = 1024
+
+// Caller makes calls on cache misses. A Cache makes concurrent misses concurrently,
+// so it must be safe for concurrent use, like a *Pool, unlike a *Client.
+type Caller interface {
+	CallContext(ctx context.Context, method string, params any) (json.RawMessage, error)
+}
+
+// CacheOptions configure a Cache.
+type CacheOptions struct {
+	// Size is the number of results kept, dropping the least recently used first.
+	// Zero or less keeps 1024.
+	Size int
+	// TTL is how long results stay valid, forever if zero.
+	TTL time.Duration
+	// Methods are the cacheable ones, which must be idempotent reads. Calls to
+	// any other method always go to the server.
+	Methods []string
+}
+
+// CacheStats count the calls of cacheable methods through a Cache.
+type CacheStats struct {
+	Hits   int
+	Misses int
+	// Shared misses waited for an identical call already in flight rather than making their own.
+	Shared int
+	// Bypassed calls went through Refresh.
+	Bypassed  int
+	Evictions int
+}
+
+// HitRate is the share of the calls answered without a call of their own,
+// from the cache or shared with an identical one.
+func (stats CacheStats) HitRate() float64 {
+	total := stats.Hits + stats.Misses + stats.Shared + stats.Bypassed
+	if total == 0 {
+		return 0
+	}
+	return float64(stats.Hits+stats.Shared) / float64(total)
+}
+
+// Cache keeps recent results of idempotent methods, keyed by the method and its
+// params, regardless of the order of their members. Errors are never cached,
+// and identical calls made while one is in flight wait for its result.
+type Cache struct {
+	caller  Caller
+	options CacheOptions
+	methods map[string]bool
+
+	mutex   sync.Mutex
+	recent  *list.List
+	entries map[string]*list.Element
+	flights map[string]*flight
+	stats   CacheStats
+}
+
+// cacheEntry is a cached result, in the recency list.
+type cacheEntry struct {
+	key     string
+	result  json.RawMessage
+	expires time.Time
+}
+
+// flight is a call made on a miss, which identical ones wait for.
+type flight struct {
+	done   chan struct{}
+	result json.RawMessage
+	err    error
+}
+
+// NewCache puts a cache in front of the caller.
+func NewCache(caller Caller, options CacheOptions) *Cache {
+	if options.Size <= 0 {
+		options.Size = defaultCacheSize
+	}
+	cache := &Cache{
+		caller: caller, options: options, methods: map[string]bool{},
+		recent: list.New(), entries: map[string]*list.Element{}, flights: map[string]*flight{},
+	}
+	for _, method := range options.Methods {
+		cache.methods[method] = true
+	}
+	return cache
+}
+
+// CallOption adjusts a single Call through a Cache.
+type CallOption int
+
+const (
+	// BypassCache sends the call to the server even on a hit, like Refresh.
+	BypassCache CallOption = iota + 1
+)
+
+// Call is CallContext without a deadline, taking options for this call alone.
+// CallContext takes none, so that a Cache is itself a Caller.
+func (cache *Cache) Call(method string, params any, options ...CallOption) (json.RawMessage, error) {
+	bypass := false
+	for _, option := range options {
+		bypass = bypass || option == BypassCache
+	}
+	return cache.call(context.Background(), method, params, bypass)
+}
+
+// CallContext returns the cached result of a cacheable method, if there is a fresh
+// one, calling the server otherwise. The result is shared, so don't modify it.
+func (cache *Cache) CallContext(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	return cache.call(ctx, method, params, false)
+}
+
+// Refresh sends the call to the server even on a hit, replacing the cached result.
+// Identical calls made meanwhile wait for it, and a miss already in flight no longer
+// stores its older result.
+func (cache *Cache) Refresh(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	return cache.call(ctx, method, params, true)
+}
+
+func (cache *Cache) call(ctx context.Context, method string, params any, bypass bool) (json.RawMessage, error) {
+	if !cache.methods[method] {
+		return cache.caller.CallContext(ctx, method, params)
+	}
+	key, err := cacheKey(method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mutex.Lock()
+	for !bypass {
+		if result, found := cache.lookup(key); found {
+			cache.stats.Hits++
+			cache.mutex.Unlock()
+			return result, nil
+		}
+		shared, inFlight := cache.flights[key]
+		if !inFlight {
+			break
+		}
+		cache.stats.Shared++
+		cache.mutex.Unlock()
+		select {
+		case <-shared.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// The owner's context ending says nothing about this one, which tries again
+		if ctx.Err() != nil || !(errors.Is(shared.err, context.Canceled) || errors.Is(shared.err, context.DeadlineExceeded)) {
+			return shared.result, shared.err
+		}
+		cache.mutex.Lock()
+		cache.stats.Shared--
+	}
+	// A refresh takes over the flight of a miss, whose result may predate it
+	own := &flight{done: make(chan struct{})}
+	if bypass {
+		cache.stats.Bypassed++
+	} else {
+		cache.stats.Misses++
+	}
+	cache.flights[key] = own
+	cache.mutex.Unlock()
+
+	own.result, own.err = cache.caller.CallContext(ctx, method, params)
+	cache.mutex.Lock()
+	if cache.flights[key] == own {
+		delete(cache.flights, key)
+		if own.err == nil {
+			cache.store(key, own.result)
+		}
+	}
+	cache.mutex.Unlock()
+	close(own.done)
+	return own.result, own.err
+}
+
+// Stats returns the counts so far.
+func (cache *Cache) Stats() CacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.stats
+}
+
+// lookup finds a fresh result, dropping an expired one.
+func (cache *Cache) lookup(key string) (json.RawMessage, bool) {
+	element, found := cache.entries[key]
+	if !found {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		cache.recent.Remove(element)
+		delete(cache.entries, key)
+		return nil, false
+	}
+	cache.recent.MoveToFront(element)
+	return entry.result, true
+}
+
+func (cache *Cache) store(key string, result json.RawMessage) {
+	entry := &cacheEntry{key: key, result: result}
+	if cache.options.TTL > 0 {
+		entry.expires = time.Now().Add(cache.options.TTL)
+	}
+	if element, found := cache.entries[key]; found {
+		element.Value = entry
+		cache.recent.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.recent.PushFront(entry)
+	for cache.recent.Len() > cache.options.Size {
+		oldest := cache.recent.Back()
+		cache.recent.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cacheEntry).key)
+		cache.stats.Evictions++
+	}
+}
+
+// cacheKey serializes the params canonically, with the members of objects
+// sorted and numbers kept exactly as they were written.
+func cacheKey(method string, params any) (string, error) {
+	encoded, err := marshal(params)
+	if err != nil {
+		return "", err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return "", err
+	}
+	canonical, err := marshal(generic)
+	if err != nil {
+		return "", err
+	}
+	return method + "\x00" + string(canonical), nil
+}
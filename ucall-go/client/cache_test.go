@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingCaller answers every call with its params, failing for `fail`, and
+// blocks calls until `release` is closed or their context ends, if set.
+type countingCaller struct {
+	sync.Mutex
+	calls   int
+	release chan struct{}
+}
+
+func (caller *countingCaller) CallContext(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	caller.Lock()
+	caller.calls++
+	caller.Unlock()
+	if caller.release != nil {
+		select {
+		case <-caller.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if method == "fail" {
+		return nil, &Error{Code: InternalError, Message: "Internal error."}
+	}
+	return marshal(params)
+}
+
+func TestCache(t *testing.T) {
+	pool := NewPool(serve(t, 0), PoolOptions{})
+	defer pool.Close()
+	cache := NewCache(pool, CacheOptions{Size: 16, Methods: []string{"validate_session"}})
+	// The same params in any member order hit the same entry
+	for _, params := range []any{
+		map[string]int{"user_id": 23, "session_id": 0},
+		json.RawMessage(`{"session_id": 0, "user_id": 23}`),
+		json.RawMessage(`{"user_id":23,"session_id":0}`),
+	} {
+		if result, err := cache.CallContext(context.Background(), "validate_session", params); err != nil || string(result) != "true" {
+			t.Fatalf("cached call returned %s, %v", result, err)
+		}
+	}
+	if _, err := cache.Refresh(context.Background(), "validate_session", map[string]int{"user_id": 23, "session_id": 0}); err != nil {
+		t.Fatal(err)
+	}
+	stats := cache.Stats()
+	if stats.Misses != 1 || stats.Hits != 2 || stats.Bypassed != 1 || stats.HitRate() != 0.5 {
+		t.Fatalf("unexpected stats %+v, hit rate %.2f", stats, stats.HitRate())
+	}
+	// Other methods always reach the server
+	if _, err := cache.CallContext(context.Background(), "sumsum", nil); !errors.As(err, new(*Error)) {
+		t.Fatalf("uncached call returned %v", err)
+	}
+	if cache.Stats() != stats {
+		t.Fatalf("an uncached method changed the stats to %+v", cache.Stats())
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	caller := &countingCaller{}
+	cache := NewCache(caller, CacheOptions{Size: 2, TTL: 50 * time.Millisecond, Methods: []string{"echo", "fail"}})
+	calls := func(params ...int) int {
+		for _, param := range params {
+			cache.CallContext(context.Background(), "echo", []int{param})
+		}
+		return caller.calls
+	}
+	if calls(1, 2, 1, 2) != 2 {
+		t.Fatalf("repeated calls made %d wire calls", caller.calls)
+	}
+	// The least recently used entry goes first
+	if calls(1, 3, 1) != 3 || calls(2) != 4 || cache.Stats().Evictions != 2 {
+		t.Fatalf("evictions made %d wire calls, %+v", caller.calls, cache.Stats())
+	}
+	time.Sleep(60 * time.Millisecond)
+	if calls(2) != 5 {
+		t.Fatal("an expired result was returned")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.CallContext(context.Background(), "fail", nil); err == nil {
+			t.Fatal("a failing call succeeded")
+		}
+	}
+	if caller.calls != 7 {
+		t.Fatal("an error was cached")
+	}
+}
+
+func TestCacheSharedMisses(t *testing.T) {
+	caller := &countingCaller{release: make(chan struct{})}
+	cache := NewCache(caller, CacheOptions{Size: 16, Methods: []string{"echo"}})
+	waiting := sync.WaitGroup{}
+	results := make([]json.RawMessage, 8)
+	for i := range results {
+		waiting.Add(1)
+		go func(i int) {
+			defer waiting.Done()
+			results[i], _ = cache.CallContext(context.Background(), "echo", map[string]int{"a": 1, "b": 2})
+		}(i)
+	}
+	for stats := cache.Stats(); stats.Misses+stats.Shared < len(results); stats = cache.Stats() {
+		time.Sleep(time.Millisecond)
+	}
+	close(caller.release)
+	waiting.Wait()
+	if caller.calls != 1 || cache.Stats().Shared != len(results)-1 {
+		t.Fatalf("identical misses made %d wire calls, %+v", caller.calls, cache.Stats())
+	}
+	for _, result := range results {
+		if string(result) != `{"a":1,"b":2}` {
+			t.Fatalf("a shared miss returned %s", result)
+		}
+	}
+}
+
+func TestCacheSharedMissOwnerCancelled(t *testing.T) {
+	caller := &countingCaller{release: make(chan struct{})}
+	cache := NewCache(caller, CacheOptions{Size: 16, Methods: []string{"echo"}})
+	ownerCtx, cancelOwner := context.WithCancel(context.Background())
+	owned := make(chan error, 1)
+	go func() {
+		_, err := cache.CallContext(ownerCtx, "echo", []int{1})
+		owned <- err
+	}()
+	for cache.Stats().Misses == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	joined := make(chan json.RawMessage, 1)
+	go func() {
+		result, _ := cache.CallContext(context.Background(), "echo", []int{1})
+		joined <- result
+	}()
+	for cache.Stats().Shared == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancelOwner()
+	if err := <-owned; !errors.Is(err, context.Canceled) {
+		t.Fatalf("the cancelled owner returned %v", err)
+	}
+	// The joined call outlives the owner's context, making its own call
+	for cache.Stats().Misses < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(caller.release)
+	if result := <-joined; string(result) != "[1]" {
+		t.Fatalf("the joined call returned %s", result)
+	}
+	if stats := cache.Stats(); caller.calls != 2 || stats.Shared != 0 {
+		t.Fatalf("made %d wire calls, %+v", caller.calls, stats)
+	}
+}
+
+// A Cache is a Caller itself, so it can front another one, and without a
+// size it still keeps results.
+func TestCacheDefaults(t *testing.T) {
+	caller := &countingCaller{}
+	var inner Caller = NewCache(caller, CacheOptions{Methods: []string{"echo"}})
+	outer := NewCache(inner, CacheOptions{Size: -1, Methods: []string{"echo"}})
+	for i := 0; i < 3; i++ {
+		if result, err := outer.CallContext(context.Background(), "echo", []int{1}); err != nil || string(result) != "[1]" {
+			t.Fatalf("cached call returned %s, %v", result, err)
+		}
+	}
+	if _, err := outer.Refresh(context.Background(), "echo", []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	// The refresh reached the inner cache, which still had the result
+	if caller.calls != 1 || outer.Stats().Hits != 2 || outer.Stats().Bypassed != 1 || inner.(*Cache).Stats().Hits != 1 {
+		t.Fatalf("made %d wire calls, outer %+v, inner %+v", caller.calls, outer.Stats(), inner.(*Cache).Stats())
+	}
+}
+
+// versionCaller answers with the number of the call, once that call is released.
+type versionCaller struct {
+	sync.Mutex
+	started  chan int
+	releases []chan struct{}
+}
+
+func (caller *versionCaller) CallContext(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	caller.Lock()
+	release := make(chan struct{})
+	caller.releases = append(caller.releases, release)
+	version := len(caller.releases)
+	caller.Unlock()
+	caller.started <- version
+	<-release
+	return json.RawMessage(fmt.Sprint(version)), nil
+}
+
+// A miss finishing after a refresh that started later never replaces its result.
+func TestCacheRefreshSupersedesMiss(t *testing.T) {
+	caller := &versionCaller{started: make(chan int, 2)}
+	cache := NewCache(caller, CacheOptions{Methods: []string{"echo"}})
+	missed, refreshed := make(chan json.RawMessage, 1), make(chan json.RawMessage, 1)
+	go func() {
+		result, _ := cache.CallContext(context.Background(), "echo", []int{1})
+		missed <- result
+	}()
+	<-caller.started
+	go func() {
+		result, _ := cache.Call("echo", []int{1}, BypassCache)
+		refreshed <- result
+	}()
+	<-caller.started
+	close(caller.releases[1])
+	if result := <-refreshed; string(result) != "2" {
+		t.Fatalf("the refresh returned %s", result)
+	}
+	close(caller.releases[0])
+	if result := <-missed; string(result) != "1" {
+		t.Fatalf("the miss returned %s", result)
+	}
+	if result, err := cache.Call("echo", []int{1}); err != nil || string(result) != "2" {
+		t.Fatalf("the cache kept %s, %v instead of the refreshed result", result, err)
+	}
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Bypassed != 1 || stats.Hits != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,333 @@
+// Package client calls ucall servers with JSON-RPC 2.0, over raw TCP or HTTP/1.1.
+//
+//	c, err := client.Dial("localhost:8545")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	valid, err := c.Call("validate_session", map[string]int{"user_id": 2, "session_id": 2})
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ErrConnectionLost is returned by calls on a client that was built with New,
+// after an interrupted call closed its connection.
+var ErrConnectionLost = errors.New("connection was closed after an interrupted call")
+
+// errPipelined is returned by RoundTrip while calls started with Start are pending.
+var errPipelined = errors.New("frames can't be sent while pipelined calls are pending")
+
+// Client is a single connection to a server, sending one call at a time, unless
+// they are pipelined with Start. It is not safe for concurrent use.
+type Client struct {
+	// HTTP wraps every request into an HTTP/1.1 POST instead of sending raw JSON.
+	// The server detects the framing of every message, but set it before the first call,
+	// as buffered raw replies aren't carried over.
+	HTTP bool
+	// Logger reports replies matching no pending call, which are skipped, if set.
+	Logger *log.Logger
+
+	conn    net.Conn
+	reader  *bufio.Reader
+	decoder *json.Decoder
+	host    string
+	nextID  int
+	// addr is where to redial after an interrupted call, empty for New
+	addr string
+	// pending are the ids of the calls sent over the connection and not waited for,
+	// with the replies that arrived while waiting for others
+	pending   map[int]*response
+	unmatched int
+	// received counts the bytes read over all connections, telling whether a
+	// failed call got any of its reply
+	received int
+	// unsent is set while the last request failed to be written, so the server never got it
+	unsent bool
+}
+
+// countingReader counts the bytes read into `count`.
+type countingReader struct {
+	reader io.Reader
+	count  *int
+}
+
+func (counting countingReader) Read(buffer []byte) (int, error) {
+	read, err := counting.reader.Read(buffer)
+	*counting.count += read
+	return read, err
+}
+
+// request is the JSON-RPC 2.0 envelope, with members in the order the examples always used.
+type request struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+	// ID is nil for notifications
+	ID *int `json:"id,omitempty"`
+}
+
+// response holds either the result or the error of a call.
+type response struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// Dial connects to the server at `addr`, like "localhost:8545".
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := New(conn)
+	c.addr = addr
+	return c, nil
+}
+
+// New takes over an established connection, for example one dialed through a proxy.
+// Such a client can't redial, so once a call is interrupted the others fail.
+func New(conn net.Conn) *Client {
+	c := &Client{}
+	c.attach(conn)
+	return c
+}
+
+func (c *Client) attach(conn net.Conn) {
+	c.conn = conn
+	c.reader = bufio.NewReader(countingReader{reader: conn, count: &c.received})
+	c.decoder = json.NewDecoder(c.reader)
+	c.pending = map[int]*response{}
+	c.host = conn.RemoteAddr().String()
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	c.pending = nil
+	return err
+}
+
+// Encode renders a JSON-RPC 2.0 request. Params are marshaled as is, so pass a
+// `json.RawMessage` to send pre-serialized ones, or nil to omit them.
+func Encode(method string, params any, id int) ([]byte, error) {
+	return marshal(request{JSONRPC: "2.0", Method: method, Params: params, ID: &id})
+}
+
+// marshal is json.Marshal leaving `<`, `>` and `&` in strings as they are.
+func marshal(value any) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// Call invokes the method and waits for its result, as long as it takes. Errors
+// reported by the server are returned as errors, with the JSON-RPC code and message.
+func (c *Client) Call(method string, params any) (json.RawMessage, error) {
+	return c.CallContext(context.Background(), method, params)
+}
+
+// CallContext is Call bounded by the context. Its deadline applies to both writing the
+// request and reading the reply, and cancelling it aborts the exchange midway, returning
+// the context's error. The reply of an aborted exchange may still arrive, so the
+// connection is closed and the next call dials a new one.
+func (c *Client) CallContext(ctx context.Context, method string, params any) (result json.RawMessage, err error) {
+	err = c.guard(ctx, func() error {
+		result, err = c.exchange(method, params)
+		return err
+	})
+	return result, err
+}
+
+// Notify sends a notification, a request without an id, which the server answers with an
+// empty object at most, skipped by the next call. It returns as soon as the request is
+// written, so errors in the call go unnoticed.
+func (c *Client) Notify(method string, params any) error {
+	body, err := marshal(request{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	return c.guard(context.Background(), func() error { return c.send(body) })
+}
+
+// RoundTrip writes a frame rendered ahead of time exactly as it is, like a request from
+// Encode, a batch from EncodeBatch, or either wrapped into an HTTP request if HTTP is set,
+// and returns the whole reply to it, the body of HTTP ones. Replies aren't decoded, so
+// error replies come back like any other and only a broken exchange fails, within the
+// context like CallContext. It would take the replies of calls pipelined with Start,
+// so it refuses to run while any are pending.
+func (c *Client) RoundTrip(ctx context.Context, frame []byte) (reply []byte, err error) {
+	if len(c.pending) > 0 {
+		return nil, errPipelined
+	}
+	err = c.guard(ctx, func() error {
+		_, err := c.conn.Write(frame)
+		if c.unsent = err != nil; c.unsent {
+			return err
+		}
+		reply, err = c.read()
+		return err
+	})
+	return reply, err
+}
+
+// guard runs one exchange within the context, redialing first if the previous one
+// was interrupted, and closing the connection if this one is.
+func (c *Client) guard(ctx context.Context, exchange func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.conn == nil {
+		if c.addr == "" {
+			return ErrConnectionLost
+		}
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", c.addr)
+		if err != nil {
+			return err
+		}
+		c.attach(conn)
+	}
+
+	conn := c.conn
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// An expired deadline unblocks the pending read or write right away
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	err := exchange()
+	if !stop() {
+		// The deadline may be reset after this call returns, so the connection is done
+		c.Close()
+	}
+
+	var server *Error
+	if err == nil || errors.As(err, &server) {
+		if c.conn != nil {
+			c.conn.SetDeadline(time.Time{})
+		}
+		return err
+	}
+	// The stream position is unknown after a failed read or write
+	c.Close()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// Codes of the errors ucall replies with, the standard JSON-RPC ones and OutOfMemory.
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+	OutOfMemory    = -32000
+)
+
+// Error is an error reply, after which the connection is still in sync.
+// Calls return it as a *Error, so callers can branch on the code:
+//
+//	var rpcErr *client.Error
+//	if errors.As(err, &rpcErr) && rpcErr.Code == client.MethodNotFound {
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Data) > 0 {
+		return fmt.Sprintf("server error %d: %s %s", e.Code, e.Message, e.Data)
+	}
+	return fmt.Sprintf("server error %d: %s", e.Code, e.Message)
+}
+
+func (c *Client) exchange(method string, params any) (json.RawMessage, error) {
+	id, err := c.start(method, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.await(id)
+}
+
+// send writes one message, wrapped into HTTP if needed.
+func (c *Client) send(body []byte) error {
+	if c.HTTP {
+		head := "POST / HTTP/1.1\r\nHost: " + c.host + "\r\nUser-Agent: ucall-go\r\nAccept: */*\r\nConnection: keep-alive\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\nContent-Type: application/json\r\n\r\n"
+		body = append([]byte(head), body...)
+	}
+	_, err := c.conn.Write(body)
+	c.unsent = err != nil
+	return err
+}
+
+// receive reads the next reply. Servers may answer batches of notifications with an
+// empty array, lone ones with an empty object, as the io_uring engine of ucall does,
+// and over HTTP notifications with an empty body. Those aren't waited for and are
+// skipped here.
+func (c *Client) receive() (json.RawMessage, error) {
+	for {
+		content, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if !isEmptyReply(content) {
+			return content, nil
+		}
+	}
+}
+
+// isEmptyReply tells `[]`, `{}` and blank replies apart without copying them.
+func isEmptyReply(content []byte) bool {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 {
+		return true
+	}
+	first, last := trimmed[0], trimmed[len(trimmed)-1]
+	if len(trimmed) < 2 || !(first == '[' && last == ']' || first == '{' && last == '}') {
+		return false
+	}
+	return len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) == 0
+}
+
+// read reads one whole message, unwrapping it from HTTP if needed.
+func (c *Client) read() (json.RawMessage, error) {
+	content := json.RawMessage{}
+	if !c.HTTP {
+		if err := c.decoder.Decode(&content); err != nil {
+			return nil, err
+		}
+		return content, nil
+	}
+	answer, err := http.ReadResponse(c.reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer answer.Body.Close()
+	return io.ReadAll(answer.Body)
+}
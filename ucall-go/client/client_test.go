@@ -0,0 +1,433 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serve answers `validate_session` like the login example does, over either framing,
+// detecting it per message just like the server, holding every reply for `delay`.
+func serve(t *testing.T, delay time.Duration) string {
+	t.Helper()
+	addr, _ := serveLogged(t, delay)
+	return addr
+}
+
+// mockLog records the sizes of the batches a mock server received, and the methods
+// of the single calls.
+type mockLog struct {
+	sync.Mutex
+	sizes   []int
+	methods []string
+}
+
+func (log *mockLog) add(size int) {
+	log.Lock()
+	defer log.Unlock()
+	log.sizes = append(log.sizes, size)
+}
+
+func (log *mockLog) call(method string) {
+	log.Lock()
+	defer log.Unlock()
+	log.methods = append(log.methods, method)
+}
+
+// calls counts the single calls to the method.
+func (log *mockLog) calls(method string) int {
+	log.Lock()
+	defer log.Unlock()
+	count := 0
+	for _, each := range log.methods {
+		if each == method {
+			count++
+		}
+	}
+	return count
+}
+
+func serveLogged(t *testing.T, delay time.Duration) (string, *mockLog) {
+	t.Helper()
+	log := &mockLog{}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go answer(conn, delay, log)
+		}
+	}()
+	return listener.Addr().String(), log
+}
+
+// mockRequest is the part of a request the mock server looks at.
+type mockRequest struct {
+	Method string `json:"method"`
+	Params struct {
+		UserID    int `json:"user_id"`
+		SessionID int `json:"session_id"`
+	} `json:"params"`
+	ID json.RawMessage `json:"id"`
+}
+
+// reply answers one request, or returns nothing for a notification. Calls to
+// `null_id` are answered like unparsable requests, and calls to `stray` with an
+// id never sent.
+func (request mockRequest) reply() string {
+	switch {
+	case request.ID == nil:
+		return ""
+	case request.Method == "null_id":
+		return `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"Invalid Request."}}`
+	case request.Method == "stray":
+		return `{"jsonrpc":"2.0","id":-1,"result":null}`
+	case request.Method == "allocate":
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"error":{"code":-32000,"message":"Out of memory.","data":{"requested":1048576}}}`, request.ID)
+	case request.Method != "validate_session":
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"error":{"code":-32601,"message":"Method not found."}}`, request.ID)
+	}
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":%t}`, request.ID, (request.Params.UserID^request.Params.SessionID)%23 == 0)
+}
+
+// answer serves one connection. Batch replies come in reverse order, which JSON-RPC
+// allows. Batches of notifications get an empty array and lone ones an empty object,
+// just like ucall sends.
+// Batches calling `fail_batch` are rejected as a whole. Calls to `hold` are only
+// answered after the next request, following its reply and one to an id never sent,
+// while calls to `drop` close the connection.
+func answer(conn net.Conn, delay time.Duration, log *mockLog) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	decoder := json.NewDecoder(reader)
+	held := ""
+	for {
+		message := json.RawMessage{}
+		prefix, err := reader.Peek(4)
+		if err != nil {
+			return
+		}
+		isHTTP := string(prefix) == "POST"
+		if isHTTP {
+			err = decodeHTTP(reader, &message)
+		} else {
+			err = decoder.Decode(&message)
+		}
+		if err != nil {
+			return
+		}
+
+		reply := ""
+		single, batch := mockRequest{}, []mockRequest{}
+		if json.Unmarshal(message, &batch) == nil {
+			log.add(len(message))
+			replies := []string{}
+			for i := len(batch) - 1; i >= 0; i-- {
+				if one := batch[i].reply(); one != "" {
+					replies = append(replies, one)
+				}
+			}
+			reply = "[" + strings.Join(replies, ",") + "]"
+			for _, request := range batch {
+				if request.Method == "fail_batch" {
+					reply = `{"jsonrpc":"2.0","id":null,"error":{"code":-32603,"message":"Internal error."}}`
+				}
+			}
+		} else if json.Unmarshal(message, &single) == nil && single.ID == nil {
+			reply = "{}"
+		} else if json.Unmarshal(message, &single) == nil {
+			log.call(single.Method)
+			reply = single.reply()
+		}
+		if single.Method == "drop" {
+			return
+		}
+		if single.Method == "hold" {
+			held = reply
+			continue
+		}
+		replies := []string{reply}
+		if held != "" {
+			replies = append(replies, `{"jsonrpc":"2.0","id":-1,"result":null}`, held)
+			held = ""
+		}
+		time.Sleep(delay)
+		for _, reply := range replies {
+			if isHTTP {
+				reply = fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\nContent-Type: application/json\r\n\r\n%s", len(reply), reply)
+			}
+			conn.Write([]byte(reply))
+		}
+	}
+}
+
+func decodeHTTP(reader *bufio.Reader, request any) error {
+	parsed, err := http.ReadRequest(reader)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(parsed.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, request)
+}
+
+func TestCall(t *testing.T) {
+	addr := serve(t, 0)
+	for _, framing := range []string{"raw", "http"} {
+		c, err := Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.HTTP = framing == "http"
+		for _, userID := range []int{2, 3, 23} {
+			result, err := c.Call("validate_session", map[string]int{"user_id": userID, "session_id": 0})
+			if err != nil {
+				t.Fatalf("%s call failed: %v", framing, err)
+			}
+			if expected := fmt.Sprint(userID%23 == 0); string(result) != expected {
+				t.Fatalf("%s call returned %s, expected %s", framing, result, expected)
+			}
+		}
+		c.Close()
+	}
+}
+
+func TestCallError(t *testing.T) {
+	c, err := Dial(serve(t, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Call("sumsum", nil); err == nil || !strings.Contains(err.Error(), "-32601") {
+		t.Fatalf("expected a method-not-found error, got %v", err)
+	}
+	// The connection stays usable after an error reply
+	if _, err := c.Call("validate_session", map[string]int{"user_id": 2, "session_id": 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	frame, err := Encode("validate_session", json.RawMessage(`{"user_id": 1, "session_id": 2}`), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"jsonrpc":"2.0","method":"validate_session","params":{"user_id":1,"session_id":2},"id":7}`
+	if string(frame) != expected {
+		t.Fatalf("got %s, expected %s", frame, expected)
+	}
+}
+
+func TestCallContextDeadline(t *testing.T) {
+	c, err := Dial(serve(t, 300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.CallContext(ctx, "validate_session", map[string]int{"user_id": 2, "session_id": 0})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if took := time.Since(start); took > 200*time.Millisecond {
+		t.Fatalf("the call outlived its deadline by %s", took-50*time.Millisecond)
+	}
+	// The late reply to the first call must not be taken for the reply to the next one
+	result, err := c.Call("validate_session", map[string]int{"user_id": 23, "session_id": 0})
+	if err != nil || string(result) != "true" {
+		t.Fatalf("the call after a timeout returned %s, %v", result, err)
+	}
+}
+
+func TestCallContextCancel(t *testing.T) {
+	c, err := Dial(serve(t, 300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err = c.CallContext(ctx, "validate_session", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the call to be cancelled, got %v", err)
+	}
+	if _, err = c.CallContext(ctx, "validate_session", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled context to fail right away, got %v", err)
+	}
+}
+
+func TestCallContextWithoutRedial(t *testing.T) {
+	conn, err := net.Dial("tcp", serve(t, 300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = c.CallContext(ctx, "validate_session", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if _, err = c.Call("validate_session", nil); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("expected the connection to be lost, got %v", err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	addr := serve(t, 0)
+	for _, framing := range []string{"raw", "http"} {
+		c, err := Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.HTTP = framing == "http"
+		for i := 0; i < 3; i++ {
+			if err := c.Notify("validate_session", map[string]int{"user_id": 23, "session_id": 0}); err != nil {
+				t.Fatalf("%s notification failed: %v", framing, err)
+			}
+		}
+		// The empty objects the server answered them with are skipped, not taken for replies
+		result, err := c.Call("validate_session", map[string]int{"user_id": 2, "session_id": 0})
+		if err != nil || string(result) != "false" || c.Unmatched() != 0 {
+			t.Fatalf("%s call after notifications returned %s, %v, skipping %d unmatched replies", framing, result, err, c.Unmatched())
+		}
+		c.Close()
+	}
+}
+
+func TestCallTypedError(t *testing.T) {
+	c, err := Dial(serve(t, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for method, code := range map[string]int{"sumsum": MethodNotFound, "allocate": OutOfMemory} {
+		_, err := c.Call(method, nil)
+		rpcErr := &Error{}
+		if !errors.As(err, &rpcErr) || rpcErr.Code != code {
+			t.Fatalf("%s returned %v, expected the code %d", method, err, code)
+		}
+		if code == OutOfMemory && string(rpcErr.Data) != `{"requested":1048576}` {
+			t.Fatalf("%s error carries the data %s", method, rpcErr.Data)
+		}
+	}
+}
+
+func TestPipelined(t *testing.T) {
+	addr := serve(t, 0)
+	for _, framing := range []string{"raw", "http"} {
+		c, err := Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.HTTP = framing == "http"
+		logged := strings.Builder{}
+		c.Logger = log.New(&logged, "", 0)
+		held, err := c.Start("hold", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		answered, err := c.Start("validate_session", map[string]int{"user_id": 23, "session_id": 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The first call is answered last, after a reply nobody waits for
+		rpcErr := &Error{}
+		if _, err := held.Wait(context.Background()); !errors.As(err, &rpcErr) || rpcErr.Code != MethodNotFound {
+			t.Fatalf("%s held call returned %v", framing, err)
+		}
+		if result, err := answered.Wait(context.Background()); err != nil || string(result) != "true" {
+			t.Fatalf("%s answered call returned %s, %v", framing, result, err)
+		}
+		if _, err := answered.Wait(context.Background()); err == nil {
+			t.Fatalf("%s call was waited for twice", framing)
+		}
+		if c.Unmatched() != 1 || !strings.Contains(logged.String(), "id -1") {
+			t.Fatalf("%s client skipped %d replies, logging %q", framing, c.Unmatched(), logged.String())
+		}
+		c.Close()
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	addr := serve(t, 0)
+	for _, framing := range []string{"raw", "http"} {
+		c, err := Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.HTTP = framing == "http"
+		wrap := func(body string) []byte {
+			if !c.HTTP {
+				return []byte(body)
+			}
+			return []byte(fmt.Sprintf("POST / HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\n\r\n%s", addr, len(body), body))
+		}
+		frame, _ := Encode("validate_session", map[string]int{"user_id": 23, "session_id": 0}, 5)
+		reply, err := c.RoundTrip(context.Background(), wrap(string(frame)))
+		if err != nil || string(reply) != `{"jsonrpc":"2.0","id":5,"result":true}` {
+			t.Fatalf("%s round trip returned %s, %v", framing, reply, err)
+		}
+		// Error replies are returned as they are, leaving the connection usable
+		frame, _ = Encode("sumsum", nil, 6)
+		if reply, err = c.RoundTrip(context.Background(), wrap(string(frame))); err != nil || !strings.Contains(string(reply), "-32601") {
+			t.Fatalf("%s round trip of a missing method returned %s, %v", framing, reply, err)
+		}
+		frame, _ = EncodeBatch([]Request{{Method: "validate_session", Params: map[string]int{"user_id": 2, "session_id": 0}}}, 7)
+		if reply, err = c.RoundTrip(context.Background(), wrap(string(frame))); err != nil || string(reply) != `[{"jsonrpc":"2.0","id":7,"result":false}]` {
+			t.Fatalf("%s round trip of a batch returned %s, %v", framing, reply, err)
+		}
+		if result, err := c.Call("validate_session", map[string]int{"user_id": 46, "session_id": 0}); err != nil || string(result) != "true" {
+			t.Fatalf("%s call after round trips returned %s, %v", framing, result, err)
+		}
+		// Frames would take the replies of pipelined calls
+		if _, err := c.Start("validate_session", nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.RoundTrip(context.Background(), wrap(string(frame))); !errors.Is(err, errPipelined) {
+			t.Fatalf("%s round trip with a pending call returned %v", framing, err)
+		}
+		c.Close()
+	}
+}
+
+func TestRoundTripDrop(t *testing.T) {
+	c, err := Dial(serve(t, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	frame, _ := Encode("drop", nil, 1)
+	if _, err := c.RoundTrip(context.Background(), frame); !errors.Is(err, io.EOF) {
+		t.Fatalf("a dropped round trip returned %v", err)
+	}
+}
+
+func TestIsEmptyReply(t *testing.T) {
+	for reply, empty := range map[string]bool{
+		"": true, " \r\n": true, "[]": true, "{}": true, " [ \n ] ": true, "{\t}\n": true,
+		"[1]": false, `{"id":1}`: false, "[": false, "]": false, "[}": false, "null": false, `"[]"`: false,
+	} {
+		if isEmptyReply([]byte(reply)) != empty {
+			t.Fatalf("%q was taken as empty: %t", reply, !empty)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// errNotPending is returned when waiting for a call twice, or after its connection was closed.
+var errNotPending = errors.New("call was already waited for, or its connection was closed")
+
+// Pending is a call sent ahead of its reply, so more calls can be pipelined behind it.
+type Pending struct {
+	client *Client
+	id     int
+}
+
+// Start sends the call without waiting for its reply, which Wait returns later.
+// Replies are matched to calls by id, so the server may answer in any order.
+func (c *Client) Start(method string, params any) (*Pending, error) {
+	pending := &Pending{client: c}
+	err := c.guard(context.Background(), func() (err error) {
+		pending.id, err = c.start(method, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// Wait reads replies until the one to this call arrives, keeping those of other
+// pending calls for their own Wait. Interrupting it closes the connection, failing
+// every call still pending on it.
+func (p *Pending) Wait(ctx context.Context) (result json.RawMessage, err error) {
+	c := p.client
+	if _, pending := c.pending[p.id]; !pending {
+		return nil, errNotPending
+	}
+	err = c.guard(ctx, func() error {
+		result, err = c.await(p.id)
+		return err
+	})
+	return result, err
+}
+
+// Unmatched counts the replies carrying an id of no pending call, which were skipped.
+func (c *Client) Unmatched() int {
+	return c.unmatched
+}
+
+// start sends the call with a fresh id, returning it.
+func (c *Client) start(method string, params any) (int, error) {
+	c.nextID++
+	body, err := Encode(method, params, c.nextID)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.send(body); err != nil {
+		return 0, err
+	}
+	c.pending[c.nextID] = nil
+	return c.nextID, nil
+}
+
+// await reads replies until the one with the id arrives.
+func (c *Client) await(id int) (json.RawMessage, error) {
+	for c.pending[id] == nil {
+		content, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		if c.deliver(content) {
+			continue
+		}
+		reply := response{}
+		if err := json.Unmarshal(content, &reply); err != nil {
+			return nil, err
+		}
+		// Errors found before the request is parsed don't carry its id
+		if reply.Error != nil && (reply.ID == nil || string(reply.ID) == "null") {
+			delete(c.pending, id)
+			return nil, reply.Error
+		}
+		c.unmatched++
+		if c.Logger != nil {
+			c.Logger.Printf("ucall: skipped a reply with the id %s matching no pending call", reply.ID)
+		}
+	}
+	reply := c.pending[id]
+	delete(c.pending, id)
+	if reply.Error != nil {
+		return nil, reply.Error
+	}
+	return reply.Result, nil
+}
+
+// deliver keeps the reply for the pending call with its id, if there is one.
+func (c *Client) deliver(content json.RawMessage) bool {
+	reply := &response{}
+	if json.Unmarshal(content, reply) != nil {
+		return false
+	}
+	id, err := strconv.Atoi(string(reply.ID))
+	if err != nil {
+		return false
+	}
+	if arrived, pending := c.pending[id]; !pending || arrived != nil {
+		return false
+	}
+	c.pending[id] = reply
+	return true
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrConnClosedBeforeResponse is returned by Pool calls whose connection the server
+// closed after the request was written and before any of the reply arrived, so it
+// may or may not have been executed. It wraps the error of the connection.
+var ErrConnClosedBeforeResponse = errors.New("connection was closed before the response")
+
+// defaultMaxIdle is the number of idle connections kept without PoolOptions.MaxIdle.
+const defaultMaxIdle = 2
+
+// PoolOptions configure a Pool.
+type PoolOptions struct {
+	// MaxIdle connections are kept open between calls, two if zero.
+	MaxIdle int
+	// MaxActive bounds the connections checked out at once, with calls beyond it
+	// waiting for one to be returned. Unbounded if zero.
+	MaxActive int
+	// IdleTimeout closes connections left idle for longer, never if zero.
+	IdleTimeout time.Duration
+	// HTTP wraps the requests of every connection into HTTP/1.1, like Client.HTTP.
+	HTTP bool
+	// Idempotent methods are safe to execute twice, so they are made once more on a
+	// fresh connection when a reused one was closed before their response.
+	Idempotent []string
+	// Dial opens the connections, for example through a proxy or from a given local
+	// address, instead of a plain net.Dialer.
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// PoolStats describe the connections of a Pool.
+type PoolStats struct {
+	// Active connections are checked out by calls in progress
+	Active int
+	Idle   int
+	// Waits counts the calls that had to wait for a connection, because of MaxActive.
+	Waits int
+	Dials int
+	// Discarded connections broke during a call, or timed out idle.
+	Discarded int
+}
+
+// Pool shares connections to a server between goroutines. Every call checks one
+// out, making the exchange on it alone, and returns it unless it broke. It is safe
+// for concurrent use.
+type Pool struct {
+	addr       string
+	options    PoolOptions
+	idempotent map[string]bool
+	// slots hold a token for every connection checked out, when MaxActive is set
+	slots chan struct{}
+
+	mutex sync.Mutex
+	// idle connections, the most recently returned last
+	idle   []idleClient
+	closed bool
+	stats  PoolStats
+}
+
+// idleClient is a connection in the pool, since it was returned.
+type idleClient struct {
+	client *Client
+	since  time.Time
+}
+
+// NewPool prepares a pool of connections to `addr`, like "localhost:8545",
+// dialing them as calls need them.
+func NewPool(addr string, options PoolOptions) *Pool {
+	if options.MaxIdle == 0 {
+		options.MaxIdle = defaultMaxIdle
+	}
+	pool := &Pool{addr: addr, options: options, idempotent: map[string]bool{}}
+	for _, method := range options.Idempotent {
+		pool.idempotent[method] = true
+	}
+	if options.MaxActive > 0 {
+		pool.slots = make(chan struct{}, options.MaxActive)
+	}
+	return pool
+}
+
+// Call invokes the method on one of the connections and waits for its result.
+func (pool *Pool) Call(method string, params any) (json.RawMessage, error) {
+	return pool.CallContext(context.Background(), method, params)
+}
+
+// CallContext is Call bounded by the context, which also bounds waiting for a
+// connection. Interrupted calls discard their connection, as Client.CallContext
+// would have to redial it. An idle connection the server closed only fails on
+// its next call. Such a call on a reused connection is made once more on a fresh
+// one if its request couldn't be written, or if its method is Idempotent. Otherwise
+// the server may have got it, and ErrConnClosedBeforeResponse is returned.
+func (pool *Pool) CallContext(ctx context.Context, method string, params any) (result json.RawMessage, err error) {
+	err = pool.exchange(ctx, pool.idempotent[method], func(c *Client) error {
+		result, err = c.CallContext(ctx, method, params)
+		return err
+	})
+	return result, err
+}
+
+// RoundTrip is Client.RoundTrip on one of the connections, which must all be
+// HTTP if the frame is. Frames aren't decoded, so they are never taken for
+// idempotent, and only sent again if they couldn't be written.
+func (pool *Pool) RoundTrip(ctx context.Context, frame []byte) (reply []byte, err error) {
+	err = pool.exchange(ctx, false, func(c *Client) error {
+		reply, err = c.RoundTrip(ctx, frame)
+		return err
+	})
+	return reply, err
+}
+
+// exchange makes the call on a connection checked out for it, once more on a
+// fresh one if the reused one was closed before any reply, as CallContext describes.
+func (pool *Pool) exchange(ctx context.Context, idempotent bool, call func(c *Client) error) error {
+	c, reused, err := pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	received := c.received
+	err = call(c)
+	if reused && closedEarly(err) && c.received == received && (c.unsent || idempotent) {
+		pool.mutex.Lock()
+		pool.stats.Discarded++
+		pool.mutex.Unlock()
+		if c, err = pool.dial(ctx); err != nil {
+			pool.abandon()
+			return err
+		}
+		received = c.received
+		err = call(c)
+	}
+	if closedEarly(err) && c.received == received && !c.unsent {
+		err = fmt.Errorf("%w: %w", ErrConnClosedBeforeResponse, err)
+	}
+	pool.put(c)
+	return err
+}
+
+// closedEarly tells whether a call failed because the server closed or reset the
+// connection, rather than on a deadline or a malformed reply.
+func closedEarly(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// Stats returns the current connections and the counts so far.
+func (pool *Pool) Stats() PoolStats {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	stats := pool.stats
+	stats.Idle = len(pool.idle)
+	return stats
+}
+
+// Close closes the idle connections, and the active ones once they are returned.
+func (pool *Pool) Close() error {
+	pool.mutex.Lock()
+	idle := pool.idle
+	pool.idle, pool.closed = nil, true
+	pool.mutex.Unlock()
+	for _, each := range idle {
+		each.client.Close()
+	}
+	return nil
+}
+
+// get checks out the most recently returned connection, or dials a new one.
+func (pool *Pool) get(ctx context.Context) (c *Client, reused bool, err error) {
+	if pool.slots != nil {
+		select {
+		case pool.slots <- struct{}{}:
+		default:
+			pool.mutex.Lock()
+			pool.stats.Waits++
+			pool.mutex.Unlock()
+			select {
+			case pool.slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, false, ctx.Err()
+			}
+		}
+	}
+
+	pool.mutex.Lock()
+	if pool.closed {
+		pool.mutex.Unlock()
+		pool.release()
+		return nil, false, net.ErrClosed
+	}
+	pool.expire()
+	if count := len(pool.idle); count > 0 {
+		c = pool.idle[count-1].client
+		pool.idle = pool.idle[:count-1]
+	}
+	pool.stats.Active++
+	pool.mutex.Unlock()
+	if c != nil {
+		return c, true, nil
+	}
+	if c, err = pool.dial(ctx); err != nil {
+		pool.abandon()
+		return nil, false, err
+	}
+	return c, false, nil
+}
+
+// dial opens a connection for a call that already counts as active.
+func (pool *Pool) dial(ctx context.Context) (*Client, error) {
+	dial := pool.options.Dial
+	if dial == nil {
+		dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		}
+	}
+	conn, err := dial(ctx, pool.addr)
+	if err != nil {
+		return nil, err
+	}
+	c := New(conn)
+	c.HTTP = pool.options.HTTP
+	pool.mutex.Lock()
+	pool.stats.Dials++
+	pool.mutex.Unlock()
+	return c, nil
+}
+
+// abandon gives up the place of a call left without a connection.
+func (pool *Pool) abandon() {
+	pool.mutex.Lock()
+	pool.stats.Active--
+	pool.mutex.Unlock()
+	pool.release()
+}
+
+// put returns the connection, closing it if it broke or the pool is full.
+// Clients close their connection on any failure but an error reply.
+func (pool *Pool) put(c *Client) {
+	pool.mutex.Lock()
+	pool.stats.Active--
+	broken := c.conn == nil
+	if broken {
+		pool.stats.Discarded++
+	}
+	keep := !broken && !pool.closed && len(pool.idle) < pool.options.MaxIdle
+	if keep {
+		pool.idle = append(pool.idle, idleClient{client: c, since: time.Now()})
+	}
+	pool.mutex.Unlock()
+	if !keep {
+		c.Close()
+	}
+	pool.release()
+}
+
+// expire closes the connections idle for longer than IdleTimeout, the oldest
+// being first. The mutex must be held.
+func (pool *Pool) expire() {
+	if pool.options.IdleTimeout <= 0 {
+		return
+	}
+	expired := 0
+	for expired < len(pool.idle) && time.Since(pool.idle[expired].since) > pool.options.IdleTimeout {
+		pool.idle[expired].client.Close()
+		expired++
+	}
+	pool.idle = pool.idle[expired:]
+	pool.stats.Discarded += expired
+}
+
+func (pool *Pool) release() {
+	if pool.slots != nil {
+		<-pool.slots
+	}
+}
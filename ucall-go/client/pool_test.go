@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	pool := NewPool(serve(t, time.Millisecond), PoolOptions{MaxIdle: 3, MaxActive: 3})
+	defer pool.Close()
+	calls := sync.WaitGroup{}
+	failures := make(chan error, 64)
+	for worker := 0; worker < 16; worker++ {
+		calls.Add(1)
+		go func(worker int) {
+			defer calls.Done()
+			for userID := worker; userID < worker+4; userID++ {
+				result, err := pool.Call("validate_session", map[string]int{"user_id": userID, "session_id": 0})
+				if expected := fmt.Sprint(userID%23 == 0); err != nil || string(result) != expected {
+					failures <- fmt.Errorf("call for %d returned %s, %v", userID, result, err)
+				}
+			}
+		}(worker)
+	}
+	calls.Wait()
+	close(failures)
+	for err := range failures {
+		t.Fatal(err)
+	}
+	stats := pool.Stats()
+	if stats.Active != 0 || stats.Idle != 3 || stats.Dials != 3 || stats.Waits == 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestPoolDiscards(t *testing.T) {
+	addr, log := serveLogged(t, 0)
+	pool := NewPool(addr, PoolOptions{IdleTimeout: 20 * time.Millisecond})
+	defer pool.Close()
+	if _, err := pool.Call("validate_session", map[string]int{"user_id": 2, "session_id": 0}); err != nil {
+		t.Fatal(err)
+	}
+	// A connection closed by the server never comes back to the pool, and the
+	// call the server may have executed isn't sent again
+	if _, err := pool.Call("drop", nil); !errors.Is(err, ErrConnClosedBeforeResponse) {
+		t.Fatalf("a dropped call returned %v", err)
+	}
+	if calls := log.calls("drop"); calls != 1 {
+		t.Fatalf("a call that isn't idempotent was sent %d times", calls)
+	}
+	if stats := pool.Stats(); stats.Idle != 0 || stats.Discarded != 1 || stats.Dials != 1 {
+		t.Fatalf("the broken connection was kept, %+v", stats)
+	}
+	if _, err := pool.Call("validate_session", map[string]int{"user_id": 2, "session_id": 0}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := pool.Call("validate_session", map[string]int{"user_id": 2, "session_id": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := pool.Stats(); stats.Dials != 3 || stats.Discarded != 2 || stats.Idle != 1 {
+		t.Fatalf("the idle connection didn't time out, %+v", stats)
+	}
+}
+
+// brokenPipeConn fails every write, like a connection the server reset while idle.
+type brokenPipeConn struct {
+	net.Conn
+}
+
+func (conn brokenPipeConn) Write([]byte) (int, error) {
+	return 0, &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}
+}
+
+func TestPoolRetries(t *testing.T) {
+	addr, log := serveLogged(t, 0)
+	pool := NewPool(addr, PoolOptions{Idempotent: []string{"drop"}})
+	defer pool.Close()
+	if _, err := pool.Call("validate_session", map[string]int{"user_id": 2, "session_id": 0}); err != nil {
+		t.Fatal(err)
+	}
+	// Idempotent calls are made once more, but only once, and only when the
+	// connection was reused
+	if _, err := pool.Call("drop", nil); !errors.Is(err, ErrConnClosedBeforeResponse) {
+		t.Fatalf("a dropped call returned %v", err)
+	}
+	if calls := log.calls("drop"); calls != 2 {
+		t.Fatalf("an idempotent call was sent %d times", calls)
+	}
+	if _, err := pool.Call("drop", nil); err == nil || log.calls("drop") != 3 {
+		t.Fatalf("a call on a fresh connection was made again or succeeded with %v", err)
+	}
+
+	// A request the server never got is sent again whatever its method
+	if _, err := pool.Call("allocate", nil); err == nil {
+		t.Fatal("the allocation succeeded")
+	}
+	pool.idle[0].client.conn = brokenPipeConn{pool.idle[0].client.conn}
+	var outOfMemory *Error
+	if _, err := pool.Call("allocate", nil); !errors.As(err, &outOfMemory) {
+		t.Fatalf("an allocation failing to be written returned %v", err)
+	}
+	if calls := log.calls("allocate"); calls != 2 {
+		t.Fatalf("the allocations were received %d times", calls)
+	}
+	if stats := pool.Stats(); stats.Dials != 5 || stats.Discarded != 4 || stats.Idle != 1 {
+		t.Fatalf("the retries were accounted as %+v", stats)
+	}
+}
+
+// idleClosingConn times out reads once no request came for `idle`, so the mock
+// server closes connections like one with a keep-alive timeout.
+type idleClosingConn struct {
+	net.Conn
+	idle time.Duration
+}
+
+func (conn idleClosingConn) Read(buffer []byte) (int, error) {
+	conn.SetReadDeadline(time.Now().Add(conn.idle))
+	return conn.Conn.Read(buffer)
+}
+
+func TestPoolRedialsClosedIdle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go answer(idleClosingConn{Conn: conn, idle: 10 * time.Millisecond}, 0, &mockLog{})
+		}
+	}()
+	for _, framing := range []string{"raw", "http"} {
+		pool := NewPool(listener.Addr().String(), PoolOptions{HTTP: framing == "http", Idempotent: []string{"validate_session"}})
+		for i := 0; i < 3; i++ {
+			if result, err := pool.Call("validate_session", map[string]int{"user_id": 23, "session_id": 0}); err != nil || string(result) != "true" {
+				t.Fatalf("%s call %d after the server closed the idle connection returned %s, %v", framing, i, result, err)
+			}
+			time.Sleep(30 * time.Millisecond)
+		}
+		if stats := pool.Stats(); stats.Dials != 3 || stats.Discarded != 2 || stats.Idle != 1 {
+			t.Fatalf("%s connections closed while idle were accounted as %+v", framing, stats)
+		}
+		pool.Close()
+	}
+}
+
+func TestPoolRoundTrip(t *testing.T) {
+	addr, log := serveLogged(t, 0)
+	dials := 0
+	pool := NewPool(addr, PoolOptions{HTTP: true, Dial: func(ctx context.Context, addr string) (net.Conn, error) {
+		dials++
+		return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}})
+	defer pool.Close()
+	wrap := func(body []byte) []byte {
+		return []byte(fmt.Sprintf("POST / HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\n\r\n%s", addr, len(body), body))
+	}
+	frame, _ := Encode("validate_session", map[string]int{"user_id": 23, "session_id": 0}, 5)
+	for i := 0; i < 2; i++ {
+		if reply, err := pool.RoundTrip(context.Background(), wrap(frame)); err != nil || string(reply) != `{"jsonrpc":"2.0","id":5,"result":true}` {
+			t.Fatalf("round trip %d returned %s, %v", i, reply, err)
+		}
+	}
+	// Frames are never taken for idempotent, whatever their method
+	frame, _ = Encode("drop", nil, 6)
+	if _, err := pool.RoundTrip(context.Background(), wrap(frame)); !errors.Is(err, ErrConnClosedBeforeResponse) || log.calls("drop") != 1 {
+		t.Fatalf("a dropped frame returned %v after %d sends", err, log.calls("drop"))
+	}
+	if stats := pool.Stats(); dials != 1 || stats.Dials != 1 || stats.Discarded != 1 {
+		t.Fatalf("%d connections were dialed through the option, accounted as %+v", dials, stats)
+	}
+}